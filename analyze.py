@@ -21,6 +21,9 @@ def group_by_windows(df):
     """Group by 1-second windows and calculate max Jerk."""
     print("\nGrouping by 1-second windows...")
     
+    # Jerk is NaN on a player's priming frames; drop those rows
+    df = df.filter(pl.col("jerk").is_not_nan())
+    
     # Create time window by flooring the time to nearest second
     df = df.with_columns([
         pl.col("time").floor().alias("time_window")
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/thesprockee/evr-playspace/evrplay"
+)
+
+// arrowBatchSize is how many records go into each Arrow record batch
+const arrowBatchSize = 64 * 1024
+
+// arrowTypes maps the parquet physical type of a column to its Arrow type
+var arrowTypes = map[string]arrow.DataType{
+	"BYTE_ARRAY": arrow.BinaryTypes.String,
+	"DOUBLE":     arrow.PrimitiveTypes.Float64,
+	"INT32":      arrow.PrimitiveTypes.Int32,
+	"INT64":      arrow.PrimitiveTypes.Int64,
+	"BOOLEAN":    arrow.FixedWidthTypes.Boolean,
+}
+
+// arrowSchema mirrors the parquet columns, and the parquet key-value
+// metadata, as an Arrow schema
+func arrowSchema(cols []evrplay.Column, metadata map[string]string) (*arrow.Schema, error) {
+	fields := make([]arrow.Field, len(cols))
+	for i, c := range cols {
+		typ, ok := arrowTypes[c.Type]
+		if !ok {
+			return nil, fmt.Errorf("column %s has no arrow type for %s", c.Name, c.Type)
+		}
+		fields[i] = arrow.Field{Name: c.Name, Type: typ}
+	}
+
+	keys := sortedKeys(metadata)
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = metadata[k]
+	}
+	md := arrow.NewMetadata(keys, values)
+	return arrow.NewSchema(fields, &md), nil
+}
+
+// arrowWriter streams records into an Arrow IPC file in batches. Like the
+// parquet writer, the file is created on the first record and finalized by
+// Close.
+type arrowWriter struct {
+	path   string
+	cols   []evrplay.Column
+	schema *arrow.Schema
+	f      *os.File
+	fw     *ipc.FileWriter
+	b      *array.RecordBuilder
+	rows   int
+}
+
+func newArrowWriter(path string, cols []evrplay.Column, metadata map[string]string) (*arrowWriter, error) {
+	schema, err := arrowSchema(cols, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &arrowWriter{path: path, cols: cols, schema: schema}, nil
+}
+
+func (w *arrowWriter) Write(r *evrplay.JerkRecord) error {
+	if w.fw == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	for i, c := range w.cols {
+		switch v := c.Value(r).(type) {
+		case string:
+			w.b.Field(i).(*array.StringBuilder).Append(v)
+		case float64:
+			w.b.Field(i).(*array.Float64Builder).Append(v)
+		case int32:
+			w.b.Field(i).(*array.Int32Builder).Append(v)
+		case int64:
+			w.b.Field(i).(*array.Int64Builder).Append(v)
+		case bool:
+			w.b.Field(i).(*array.BooleanBuilder).Append(v)
+		default:
+			return fmt.Errorf("column %s has unsupported value type %T", c.Name, v)
+		}
+	}
+
+	w.rows++
+	if w.rows == arrowBatchSize {
+		return w.flushBatch()
+	}
+	return nil
+}
+
+func (w *arrowWriter) open() error {
+	f, err := os.Create(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	mem := memory.NewGoAllocator()
+	fw, err := ipc.NewFileWriter(f, ipc.WithSchema(w.schema), ipc.WithAllocator(mem))
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to create arrow writer: %w", err)
+	}
+
+	w.f, w.fw, w.b = f, fw, array.NewRecordBuilder(mem, w.schema)
+	return nil
+}
+
+// flushBatch writes the buffered rows as one record batch
+func (w *arrowWriter) flushBatch() error {
+	rec := w.b.NewRecord()
+	defer rec.Release()
+
+	w.rows = 0
+	if err := w.fw.Write(rec); err != nil {
+		return fmt.Errorf("failed to write record batch: %w", err)
+	}
+	return nil
+}
+
+func (w *arrowWriter) Close() error {
+	if w.fw == nil {
+		return nil
+	}
+	defer w.b.Release()
+
+	if w.rows > 0 {
+		if err := w.flushBatch(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	if err := w.fw.Close(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("failed to finalize arrow file: %w", err)
+	}
+	return w.f.Close()
+}
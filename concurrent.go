@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+
+	"github.com/thesprockee/evr-playspace/evrplay"
+)
+
+// recordBuffer is a RecordSink that keeps every record in memory
+type recordBuffer struct {
+	records []evrplay.JerkRecord
+}
+
+func (b *recordBuffer) Write(r *evrplay.JerkRecord) error {
+	b.records = append(b.records, *r)
+	return nil
+}
+
+func (b *recordBuffer) Close() error {
+	return nil
+}
+
+// fileResult is the outcome of processing one input file on a worker. done
+// is closed once the other fields are set.
+type fileResult struct {
+	path    string
+	records []evrplay.JerkRecord
+	stats   evrplay.Stats
+	err     error
+	done    chan struct{}
+	sem     chan struct{} // the worker slot held until release
+}
+
+// release drops the result's records and frees its worker slot for the
+// next file. Call it once the records are written.
+func (r *fileResult) release() {
+	r.records = nil
+	<-r.sem
+}
+
+// startFileWorkers processes paths on up to workers goroutines and returns
+// their results in input order. Every file gets its own Processor, so player
+// state never carries over from one file to the next. Each file is read with
+// read, and files still being read when ctx is done stop early with its
+// error. A file's slot stays taken until its result is released, so no more
+// than workers files are read or held in memory at once.
+func startFileWorkers(ctx context.Context, cfg evrplay.Config, paths []string, workers int, read inputReader) []*fileResult {
+	sem := make(chan struct{}, workers)
+	results := make([]*fileResult, len(paths))
+	for i, path := range paths {
+		results[i] = &fileResult{path: path, done: make(chan struct{}), sem: sem}
+	}
+
+	go func() {
+		for _, res := range results {
+			sem <- struct{}{}
+			go func(res *fileResult) {
+				defer close(res.done)
+
+				var buf recordBuffer
+				p := evrplay.NewProcessor(cfg, &buf)
+				res.err = read(ctx, p, res.path)
+				if err := p.Flush(); res.err == nil {
+					res.err = err
+				}
+				res.records = buf.records
+				res.stats = p.Stats()
+			}(res)
+		}
+	}()
+	return results
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thesprockee/evr-playspace/evrplay"
+)
+
+func TestFileWorkersHoldSlotsUntilReleased(t *testing.T) {
+	var started atomic.Int32
+	read := func(ctx context.Context, p *evrplay.Processor, path string) error {
+		started.Add(1)
+		return nil
+	}
+	waitStarted := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for started.Load() < want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		// Give a file that should not start yet the chance to
+		time.Sleep(20 * time.Millisecond)
+		if got := started.Load(); got != want {
+			t.Fatalf("%d files started, want %d", got, want)
+		}
+	}
+
+	paths := []string{"a", "b", "c", "d", "e"}
+	results := startFileWorkers(context.Background(), evrplay.DefaultConfig(), paths, 2, read)
+	// Both slots are taken by finished but unreleased files
+	waitStarted(2)
+	for i, res := range results {
+		<-res.done
+		if res.path != paths[i] {
+			t.Fatalf("result %d is for %s, want %s", i, res.path, paths[i])
+		}
+		res.release()
+		waitStarted(int32(min(i+3, len(paths))))
+	}
+}
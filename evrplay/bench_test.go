@@ -0,0 +1,119 @@
+package evrplay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// nopSink discards records so only the processing cost is measured
+type nopSink struct{}
+
+func (nopSink) Write(*JerkRecord) error { return nil }
+func (nopSink) Close() error            { return nil }
+
+// frameInterval is the spacing between synthetic frames, matching the
+// EchoVR API's 60 Hz update rate
+const frameInterval = 1.0 / 60
+
+// benchTeamSizes are the players per team each benchmark runs with
+var benchTeamSizes = []int{1, 4, 8}
+
+// syntheticFrame builds a frame at time t with teams of the given size whose
+// players move along circles, so every derivative is non-trivial
+func syntheticFrame(t float64, teamSize int) EchoVRFrame {
+	frame := EchoVRFrame{
+		SessionID: "bench",
+		Time:      t,
+		Disc:      &Disc{Position: Vec3{Z: math.Sin(t)}, Velocity: Vec3{Z: math.Cos(t)}},
+	}
+	for ti := 0; ti < 2; ti++ {
+		var team Team
+		for pi := 0; pi < teamSize; pi++ {
+			phase := t + float64(ti*teamSize+pi)
+			team.Players = append(team.Players, Player{
+				UserID:   fmt.Sprintf("player%d_%d", ti, pi),
+				Position: Vec3{X: 10 * math.Cos(phase), Y: float64(pi), Z: 10 * math.Sin(phase)},
+				Velocity: Vec3{X: -10 * math.Sin(phase), Z: 10 * math.Cos(phase)},
+				Forward:  Vec3{X: math.Cos(phase), Z: math.Sin(phase)},
+				Up:       Vec3{Y: 1},
+			})
+		}
+		frame.Teams = append(frame.Teams, team)
+	}
+	return frame
+}
+
+// runTeamSizes runs bench as a sub-benchmark for each of benchTeamSizes
+func runTeamSizes(b *testing.B, bench func(b *testing.B, teamSize int)) {
+	for _, size := range benchTeamSizes {
+		b.Run(fmt.Sprintf("team=%d", size), func(b *testing.B) { bench(b, size) })
+	}
+}
+
+// BenchmarkProcessFrame measures the state update and derivative math for
+// one frame, excluding JSON decoding
+func BenchmarkProcessFrame(b *testing.B) {
+	runTeamSizes(b, func(b *testing.B, teamSize int) {
+		frames := make([]EchoVRFrame, 1024)
+		for i := range frames {
+			frames[i] = syntheticFrame(float64(i)*frameInterval, teamSize)
+		}
+		p := NewProcessor(DefaultConfig(), nopSink{})
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// Keep the clock moving forward across passes over the frames
+			frame := frames[i%len(frames)]
+			frame.Time = float64(i) * frameInterval
+			if err := p.ProcessFrame(frame); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUnmarshalFrame measures decoding one JSON frame on its own
+func BenchmarkUnmarshalFrame(b *testing.B) {
+	runTeamSizes(b, func(b *testing.B, teamSize int) {
+		line, err := json.Marshal(syntheticFrame(0, teamSize))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ReportAllocs()
+		b.SetBytes(int64(len(line)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var frame EchoVRFrame
+			if err := json.Unmarshal(line, &frame); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkProcessStream measures the whole path from JSON lines to records,
+// per frame
+func BenchmarkProcessStream(b *testing.B) {
+	runTeamSizes(b, func(b *testing.B, teamSize int) {
+		var input bytes.Buffer
+		enc := json.NewEncoder(&input)
+		for i := 0; i < b.N; i++ {
+			if err := enc.Encode(syntheticFrame(float64(i)*frameInterval, teamSize)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		p := NewProcessor(DefaultConfig(), nopSink{})
+
+		b.ReportAllocs()
+		b.SetBytes(int64(input.Len() / b.N))
+		b.ResetTimer()
+		if err := p.ProcessStream(&input); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package evrplay
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Diff selects how derivatives are estimated from velocity samples
+type Diff int
+
+const (
+	// DiffForward differences each sample with the ones before it, so
+	// records are written as soon as their sample arrives
+	DiffForward Diff = iota
+	// DiffCentral uses a stencil centered on each sample, which removes
+	// the phase lag of forward differences at the cost of holding every
+	// record back until the player's next sample
+	DiffCentral
+)
+
+// ParseDiff returns the stencil with the given --diff name
+func ParseDiff(name string) (Diff, error) {
+	switch strings.ToLower(name) {
+	case "forward":
+		return DiffForward, nil
+	case "central":
+		return DiffCentral, nil
+	default:
+		return 0, fmt.Errorf("unknown difference method %q (want forward or central)", name)
+	}
+}
+
+func (d Diff) String() string {
+	if d == DiffCentral {
+		return "central"
+	}
+	return "forward"
+}
+
+// centralSample is a player's record held back until their next sample
+type centralSample struct {
+	record   JerkRecord
+	velocity Vec3 // smoothed velocity at the record's time
+
+	// prevVelocity is the smoothed velocity of the sample before the
+	// record and dt the time since it, which is 0 when the record began a
+	// history and has no predecessor
+	prevVelocity Vec3
+	dt           float64
+
+	// jerk is the central jerk of the record before this one, if it had one
+	jerk    Vec3
+	hasJerk bool
+}
+
+// centralDifferences recomputes accel, jerk, and snap with central
+// differences. Each record needs the player's next sample, so it is
+// released one sample late; at a history's boundaries, where no centered
+// stencil fits, the record keeps its forward differences.
+type centralDifferences struct {
+	held    map[PlayerKey]*centralSample
+	gravity *Vec3 // Config.Gravity
+}
+
+func newCentralDifferences(gravity *Vec3) *centralDifferences {
+	return &centralDifferences{held: make(map[PlayerKey]*centralSample), gravity: gravity}
+}
+
+// next holds record back in place of the player's previous record, which
+// it returns with its derivatives centered on it when one was held. velocity
+// is the record's smoothed velocity and dt its time step, 0 when it starts
+// a history.
+func (c *centralDifferences) next(key PlayerKey, record JerkRecord, velocity Vec3, dt float64) (JerkRecord, bool) {
+	s, held := c.held[key]
+	if !held {
+		s = &centralSample{}
+		c.held[key] = s
+	}
+
+	out := s.record
+	if held && dt > 0 && s.dt > 0 {
+		h1, h2 := s.dt, dt
+		// Weighting each side by the other's step squared cancels the
+		// first-order error that a plain span difference leaves on an
+		// uneven grid
+		accel := velocity.Sub(s.velocity).Scale(h1 * h1).
+			Add(s.velocity.Sub(s.prevVelocity).Scale(h2 * h2)).
+			Scale(1 / (h1 * h2 * (h1 + h2)))
+		jerk := velocity.Sub(s.velocity).Scale(1 / h2).
+			Sub(s.velocity.Sub(s.prevVelocity).Scale(1 / h1)).
+			Scale(2 / (h1 + h2))
+
+		// The stencil spans from the previous sample to the next one
+		out.DeltaTime = h1 + h2
+		out.Accel = accel.Magnitude()
+		if c.gravity != nil {
+			out.AccelComp = accel.Sub(*c.gravity).Magnitude()
+		}
+		out.AccelAlignment = math.NaN()
+		if a, ok := accel.Normalize(); ok {
+			if v, ok := s.velocity.Normalize(); ok {
+				out.AccelAlignment = a.Dot(v)
+			}
+		}
+		out.Curvature = curvature(s.velocity, accel)
+		out.Jerk, out.JerkValid = jerk.Magnitude(), true
+		out.JerkX, out.JerkY, out.JerkZ = jerk.X, jerk.Y, jerk.Z
+		if s.hasJerk {
+			out.Snap = jerk.Sub(s.jerk).Magnitude() / h1
+		}
+		s.jerk, s.hasJerk = jerk, true
+	} else {
+		s.hasJerk = false
+	}
+
+	if dt > 0 {
+		s.prevVelocity, s.dt = s.velocity, dt
+	} else {
+		s.dt, s.hasJerk = 0, false
+	}
+	s.record, s.velocity = record, velocity
+	return out, held
+}
+
+// drain returns every held record, sorted by session and user, and forgets
+// them
+func (c *centralDifferences) drain() []JerkRecord {
+	keys := make([]PlayerKey, 0, len(c.held))
+	for key := range c.held {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].SessionID != keys[j].SessionID {
+			return keys[i].SessionID < keys[j].SessionID
+		}
+		return keys[i].UserID < keys[j].UserID
+	})
+
+	records := make([]JerkRecord, len(keys))
+	for i, key := range keys {
+		records[i] = c.held[key].record
+	}
+	clear(c.held)
+	return records
+}
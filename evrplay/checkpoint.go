@@ -0,0 +1,88 @@
+package evrplay
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// checkpointVersion is bumped whenever PlayerState changes in a way that
+// makes older checkpoints unusable
+const checkpointVersion = 1
+
+// Checkpoint is a snapshot of every player's state, from which a later run
+// can resume a stream without recomputing its history
+type Checkpoint struct {
+	players []checkpointPlayer
+}
+
+// checkpointFile is the gob encoding of a Checkpoint
+type checkpointFile struct {
+	Version int
+	Players []checkpointPlayer
+}
+
+// checkpointPlayer holds a PlayerState along with the unexported fields gob
+// cannot see
+type checkpointPlayer struct {
+	Key    PlayerKey
+	State  PlayerState
+	Window []Vec3 // velocity samples, oldest first
+	LastDt float64
+}
+
+// Checkpoint returns a snapshot of the processor's player state. Records
+// held back for central differences are not part of it, so call Flush first.
+func (p *Processor) Checkpoint() *Checkpoint {
+	c := &Checkpoint{players: make([]checkpointPlayer, 0, len(p.states))}
+	for key, state := range p.states {
+		c.players = append(c.players, checkpointPlayer{
+			Key:    key,
+			State:  *state,
+			Window: state.window.ordered(),
+			LastDt: state.lastDt,
+		})
+	}
+	sort.Slice(c.players, func(i, j int) bool {
+		a, b := c.players[i].Key, c.players[j].Key
+		if a.SessionID != b.SessionID {
+			return a.SessionID < b.SessionID
+		}
+		return a.UserID < b.UserID
+	})
+	return c
+}
+
+// Restore replaces the state of every player in c with the checkpointed
+// one, as though the processor had seen the stream that produced it. The
+// velocity windows are fitted to the processor's SmoothWindow.
+func (p *Processor) Restore(c *Checkpoint) {
+	for _, saved := range c.players {
+		state := saved.State
+		state.lastDt = saved.LastDt
+		state.window = newVelocityWindow(p.cfg.SmoothWindow)
+		for _, v := range saved.Window {
+			state.window.push(v)
+		}
+		p.states[saved.Key] = &state
+	}
+}
+
+// Encode writes the checkpoint to w
+func (c *Checkpoint) Encode(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(checkpointFile{Version: checkpointVersion, Players: c.players})
+}
+
+// ReadCheckpoint decodes a checkpoint written by Encode, rejecting one from
+// an incompatible version
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	var f checkpointFile
+	if err := gob.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+	if f.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint has version %d, want %d", f.Version, checkpointVersion)
+	}
+	return &Checkpoint{players: f.Players}, nil
+}
@@ -0,0 +1,79 @@
+package evrplay
+
+// ContactEvent records two players coming within Config.ContactRadius of
+// each other. UserA sorts before UserB.
+type ContactEvent struct {
+	SessionID string
+	Time      float64
+	UserA     string
+	UserB     string
+	Distance  float64
+}
+
+// contactPair identifies two players, ordered by user ID
+type contactPair struct {
+	a, b string
+}
+
+// contactDetector finds the pairs of players within radius of each other in
+// every frame. A pair is reported once when it comes into contact, and again
+// only after it has been apart for at least one frame of its session.
+type contactDetector struct {
+	radius  float64
+	active  map[string]map[contactPair]bool // pairs in contact, per session
+	next    map[contactPair]bool            // reused to build the next set
+	players []Player                        // reused to flatten the teams
+}
+
+func newContactDetector(radius float64) *contactDetector {
+	return &contactDetector{
+		radius: radius,
+		active: make(map[string]map[contactPair]bool),
+		next:   make(map[contactPair]bool),
+	}
+}
+
+// detect calls emit for every pair that came into contact in frame
+func (d *contactDetector) detect(frame EchoVRFrame, emit func(ContactEvent) error) error {
+	d.players = d.players[:0]
+	for _, team := range frame.Teams {
+		d.players = append(d.players, team.Players...)
+	}
+
+	prev := d.active[frame.SessionID]
+	clear(d.next)
+	for i := range d.players {
+		for j := i + 1; j < len(d.players); j++ {
+			a, b := d.players[i], d.players[j]
+			dist := a.Position.Distance(b.Position)
+			if !(dist <= d.radius) {
+				continue
+			}
+			if b.UserID < a.UserID {
+				a, b = b, a
+			}
+			pair := contactPair{a.UserID, b.UserID}
+			d.next[pair] = true
+			if prev[pair] {
+				continue
+			}
+			event := ContactEvent{SessionID: frame.SessionID, Time: frame.Time, UserA: a.UserID, UserB: b.UserID, Distance: dist}
+			if err := emit(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The pairs just seen become the session's active set, and its old set
+	// is recycled for the next frame
+	if prev == nil {
+		prev = make(map[contactPair]bool)
+	}
+	d.active[frame.SessionID], d.next = d.next, prev
+	return nil
+}
+
+// discardContact drops a contact event
+func discardContact(ContactEvent) error {
+	return nil
+}
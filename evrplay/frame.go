@@ -0,0 +1,114 @@
+package evrplay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Player represents a player in EchoVR
+type Player struct {
+	UserID      string `json:"userid"`
+	DisplayName string `json:"player_name"`
+	Role        string `json:"role"`
+	Position    Vec3   `json:"position"`
+	Velocity    Vec3   `json:"velocity"`
+	Forward     Vec3   `json:"forward"`
+	Up          Vec3   `json:"up"`
+}
+
+// EchoVRFrame represents a frame of data from EchoVR
+type EchoVRFrame struct {
+	SessionID string  `json:"sessionid"`
+	Time      float64 `json:"game_clock"`
+	Teams     []Team  `json:"teams"`
+	Disc      *Disc   `json:"disc,omitempty"`
+
+	// RealTime is the wall-clock capture time of the frame, which feeds
+	// name real_time or capture_time; it is zero when the input has neither
+	RealTime    Timestamp `json:"real_time"`
+	CaptureTime Timestamp `json:"capture_time"`
+}
+
+// Timestamp is a wall-clock time encoded as either milliseconds since the
+// Unix epoch or an RFC 3339 string. The zero value means absent.
+type Timestamp struct {
+	time.Time
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp: %w", err)
+		}
+		t.Time = parsed
+		return nil
+	default:
+		var millis float64
+		if err := json.Unmarshal(data, &millis); err != nil {
+			return err
+		}
+		t.Time = time.UnixMilli(0).Add(time.Duration(millis * float64(time.Millisecond)))
+		return nil
+	}
+}
+
+// Disc represents the disc in EchoVR. Frames without a disc leave it nil.
+type Disc struct {
+	Position Vec3 `json:"position"`
+	Velocity Vec3 `json:"velocity"`
+}
+
+// Team represents a team with players
+type Team struct {
+	Players []Player `json:"players"`
+}
+
+// scaleInto stores a copy of f in dst with every position and velocity
+// multiplied by scale, reusing dst's slices and storing the disc in disc.
+// Forward and up are directions, so they are not scaled.
+func (f *EchoVRFrame) scaleInto(dst *EchoVRFrame, disc *Disc, scale float64) {
+	teams := dst.Teams[:0]
+	for i, team := range f.Teams {
+		var players []Player
+		if i < cap(teams) {
+			// Reuse the player slice left in this slot by the last frame
+			players = teams[:i+1][i].Players[:0]
+		}
+		for _, player := range team.Players {
+			player.Position = player.Position.Scale(scale)
+			player.Velocity = player.Velocity.Scale(scale)
+			players = append(players, player)
+		}
+		teams = append(teams, Team{Players: players})
+	}
+	*dst = EchoVRFrame{SessionID: f.SessionID, Time: f.Time, RealTime: f.RealTime, Teams: teams}
+	if f.Disc != nil {
+		*disc = Disc{Position: f.Disc.Position.Scale(scale), Velocity: f.Disc.Velocity.Scale(scale)}
+		dst.Disc = disc
+	}
+}
+
+// reset empties the frame for decoding the next line into it, keeping the
+// team and player slices' capacity. JSON decoding fills reused slice elements
+// in place, so every element is zeroed to stop fields missing from a shorter
+// or sparser frame from leaking over from the previous one.
+func (f *EchoVRFrame) reset() {
+	teams := f.Teams[:cap(f.Teams)]
+	for i := range teams {
+		players := teams[i].Players[:cap(teams[i].Players)]
+		clear(players)
+		teams[i] = Team{Players: players[:0]}
+	}
+	*f = EchoVRFrame{Teams: teams[:0]}
+}
@@ -0,0 +1,126 @@
+package evrplay
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Schema selects the JSON layout of input frames
+type Schema int
+
+const (
+	// SchemaSimple is the flattened layout described by EchoVRFrame
+	SchemaSimple Schema = iota
+	// SchemaOfficial is the layout served by the EchoVR /session API
+	SchemaOfficial
+)
+
+// ParseSchema returns the schema with the given --schema name
+func ParseSchema(name string) (Schema, error) {
+	switch strings.ToLower(name) {
+	case "simple":
+		return SchemaSimple, nil
+	case "official":
+		return SchemaOfficial, nil
+	default:
+		return 0, fmt.Errorf("unknown schema %q (want simple or official)", name)
+	}
+}
+
+func (s Schema) String() string {
+	if s == SchemaOfficial {
+		return "official"
+	}
+	return "simple"
+}
+
+// decodeFrame parses one line of input in the given schema
+func decodeFrame(schema Schema, line []byte, frame *EchoVRFrame) error {
+	if schema != SchemaOfficial {
+		if err := json.Unmarshal(line, frame); err != nil {
+			return err
+		}
+	} else {
+		var raw officialFrame
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return err
+		}
+		raw.normalize(frame)
+	}
+
+	if frame.RealTime.IsZero() {
+		frame.RealTime = frame.CaptureTime
+	}
+	return nil
+}
+
+// officialTeams is how many entries of the official teams array hold
+// players; the blue and orange teams come first, followed by spectators
+const officialTeams = 2
+
+// officialVec is a vector encoded as an [x, y, z] array
+type officialVec [3]float64
+
+func (v officialVec) vec3() Vec3 {
+	return Vec3{X: v[0], Y: v[1], Z: v[2]}
+}
+
+// officialFrame mirrors the parts of an EchoVR /session response that are
+// needed to build an EchoVRFrame
+type officialFrame struct {
+	SessionID   string    `json:"sessionid"`
+	GameClock   float64   `json:"game_clock"`
+	RealTime    Timestamp `json:"real_time"`
+	CaptureTime Timestamp `json:"capture_time"`
+	Disc        *struct {
+		Position officialVec `json:"position"`
+		Velocity officialVec `json:"velocity"`
+	} `json:"disc"`
+	Teams []struct {
+		Team    string `json:"team"`
+		Players []struct {
+			UserID json.Number `json:"userid"`
+			Name   string      `json:"name"`
+			Role   string      `json:"role"`
+			Head   struct {
+				Position officialVec `json:"position"`
+				Forward  officialVec `json:"forward"`
+				Up       officialVec `json:"up"`
+			} `json:"head"`
+			Velocity officialVec `json:"velocity"`
+		} `json:"players"`
+	} `json:"teams"`
+}
+
+// normalize converts the official layout into the internal model. The
+// teams keep their official order, so blue is team 0 and orange team 1, and
+// spectators are dropped. Players are located by their headset. The official
+// game_clock counts down, so it is negated to make time increase.
+func (f *officialFrame) normalize(frame *EchoVRFrame) {
+	*frame = EchoVRFrame{SessionID: f.SessionID, Time: -f.GameClock, RealTime: f.RealTime, CaptureTime: f.CaptureTime}
+	if f.Disc != nil {
+		frame.Disc = &Disc{Position: f.Disc.Position.vec3(), Velocity: f.Disc.Velocity.vec3()}
+	}
+
+	teams := f.Teams
+	if len(teams) > officialTeams {
+		teams = teams[:officialTeams]
+	}
+	frame.Teams = make([]Team, len(teams))
+	for ti, team := range teams {
+		players := make([]Player, len(team.Players))
+		for pi, p := range team.Players {
+			players[pi] = Player{
+				UserID:      p.UserID.String(),
+				DisplayName: p.Name,
+				Role:        p.Role,
+				Position:    p.Head.Position.vec3(),
+				Velocity:    p.Velocity.vec3(),
+				Forward:     p.Head.Forward.vec3(),
+				Up:          p.Head.Up.vec3(),
+			}
+		}
+		frame.Teams[ti] = Team{Players: players}
+	}
+}
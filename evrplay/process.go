@@ -0,0 +1,941 @@
+// Package evrplay turns streams of EchoVR frames into per-player movement
+// features such as speed, acceleration, and jerk.
+package evrplay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Config controls how frames are turned into records
+type Config struct {
+	// Schema is the JSON layout of the input lines
+	Schema Schema
+	// Strict rejects lines that lack a field the schema requires, such as
+	// teams or a player's position, as parse errors with a *FieldError
+	// instead of decoding the missing fields as zero values
+	Strict bool
+	// AssumeUniformDt treats consecutive frames as one time unit apart
+	// instead of using game_clock deltas
+	AssumeUniformDt bool
+	// CollapseDuplicates skips a player sample that repeats the previous
+	// one's time, position, and velocity
+	CollapseDuplicates bool
+	// DropOutOfOrder drops samples whose clock does not advance past the
+	// player's previous sample
+	DropOutOfOrder bool
+	// Derivatives is the highest derivative column to output:
+	// 1=accel, 2=jerk, 3=snap
+	Derivatives int
+	// Diff selects the finite-difference stencil for the derivatives
+	Diff Diff
+	// PerAxis adds the signed per-axis jerk columns
+	PerAxis bool
+	// DisplayNames adds the players' display names as a column
+	DisplayNames bool
+	// Orientation adds the angular velocity and heading change rate columns
+	Orientation bool
+	// Kinematics adds the raw per-axis position and velocity columns
+	Kinematics bool
+	// TeamSwaps adds the team_changed column
+	TeamSwaps bool
+	// SmoothWindow averages each player's last SmoothWindow velocity
+	// samples before differentiating; 0 or 1 disables smoothing
+	SmoothWindow int
+	// RejoinGap, when positive, is the longest gap in seconds between a
+	// player's samples that derivatives are computed across. A player
+	// missing for longer starts a fresh history when they reappear.
+	RejoinGap float64
+	// ResampleHz, when positive, interpolates frames onto a uniform grid
+	// of this many samples per second before computing features
+	ResampleHz float64
+	// ResampleMaxGap is the longest span in seconds between input frames
+	// that is interpolated across when resampling; later grid times start a
+	// fresh history
+	ResampleMaxGap float64
+	// UnitsScale multiplies every position and velocity to convert the
+	// input's distance unit to meters; 0 or 1 leaves them as they are.
+	// Thresholds such as MaxSpeed apply to the converted values.
+	UnitsScale float64
+	// MaxSpeed, when positive, flags records whose implied speed since the
+	// player's previous sample exceeds it as suspect position glitches
+	MaxSpeed float64
+	// Limit, when positive, stops processing once that many records have
+	// been written
+	Limit int
+	// MinJerk drops records whose jerk is below it when positive
+	MinJerk float64
+	// NaNPolicy decides what happens to records whose jerk came out NaN or
+	// infinite, such as from corrupt velocities
+	NaNPolicy NaNPolicy
+	// MinDt, when positive, is the shortest time step the derivatives are
+	// computed over; MinDtPolicy decides what happens to a sample that
+	// follows the player's previous one more closely
+	MinDt       float64
+	MinDtPolicy MinDtPolicy
+	// MaxPlayers, when positive, skips frames with more players than this
+	// as malformed, before they reach the pairwise computations
+	MaxPlayers int
+	// EveryNth, when above 1, keeps only every EveryNth frame of each
+	// session, counting from its first, for quick looks at long captures
+	EveryNth int
+	// SeedFrames is how many frames at the start of the input only warm up
+	// player state, with their records and contacts suppressed, so a chunk
+	// that overlaps the previous one by that many frames continues its
+	// derivatives without a gap
+	SeedFrames int
+	// ContactRadius, when positive, reports pairs of players that come
+	// within this distance of each other to OnContact
+	ContactRadius float64
+	// OnContact receives the contact events; an error it returns stops
+	// processing as a *SinkError
+	OnContact func(ContactEvent) error
+	// Gravity, when set, adds the accel_compensated column: the acceleration
+	// with this constant vector removed, which isolates the player's own
+	// motion from gravity
+	Gravity *Vec3
+	// Window, when set, skips frames whose time lies outside it before they
+	// update any state. Under SchemaOfficial the frame time is the negated
+	// game_clock.
+	Window *TimeWindow
+	// Sessions and Users restrict processing to the given IDs when non-empty
+	Sessions map[string]bool
+	Users    map[string]bool
+
+	// OnParseError, when set, is called for every line that is not valid
+	// JSON. Such lines are skipped either way.
+	OnParseError func(line int, err error)
+	// OnProgress, when set, is called with the counters so far after every
+	// ProgressEvery input lines
+	OnProgress    func(Stats)
+	ProgressEvery int
+	// OnFrame, when set, is called with every input frame that passes the
+	// session and time filters, before any downsampling or resampling
+	OnFrame func(EchoVRFrame)
+	// MaxErrors, when positive, aborts processing with
+	// ErrTooManyParseErrors once more than MaxErrors lines failed to parse
+	MaxErrors int
+}
+
+// TimeWindow is an inclusive range of frame times
+type TimeWindow struct {
+	Start, End float64
+}
+
+// Contains reports whether t lies within the window
+func (w TimeWindow) Contains(t float64) bool {
+	return t >= w.Start && t <= w.End
+}
+
+// NaNPolicy selects how records with a non-finite jerk are handled
+type NaNPolicy int
+
+const (
+	// NaNKeep writes the record with a NaN jerk and JerkValid false
+	NaNKeep NaNPolicy = iota
+	// NaNSkip drops the record
+	NaNSkip
+)
+
+// ParseNaNPolicy returns the policy with the given --nan-policy name
+func ParseNaNPolicy(name string) (NaNPolicy, error) {
+	switch strings.ToLower(name) {
+	case "keep":
+		return NaNKeep, nil
+	case "skip":
+		return NaNSkip, nil
+	default:
+		return 0, fmt.Errorf("unknown NaN policy %q (want skip or keep)", name)
+	}
+}
+
+func (n NaNPolicy) String() string {
+	if n == NaNSkip {
+		return "skip"
+	}
+	return "keep"
+}
+
+// MinDtPolicy selects how samples closer together than MinDt are handled
+type MinDtPolicy int
+
+const (
+	// MinDtClamp keeps the sample but divides by MinDt instead of the
+	// shorter time step
+	MinDtClamp MinDtPolicy = iota
+	// MinDtSkip drops the sample, so the player's next one is differenced
+	// against the sample before it
+	MinDtSkip
+)
+
+// ParseMinDtPolicy returns the policy with the given --min-dt-policy name
+func ParseMinDtPolicy(name string) (MinDtPolicy, error) {
+	switch strings.ToLower(name) {
+	case "clamp":
+		return MinDtClamp, nil
+	case "skip":
+		return MinDtSkip, nil
+	default:
+		return 0, fmt.Errorf("unknown min-dt policy %q (want clamp or skip)", name)
+	}
+}
+
+func (m MinDtPolicy) String() string {
+	if m == MinDtSkip {
+		return "skip"
+	}
+	return "clamp"
+}
+
+// DefaultConfig returns the configuration used by the CLI without flags
+func DefaultConfig() Config {
+	return Config{Derivatives: 2, CollapseDuplicates: true}
+}
+
+// RecordSink receives records as they are produced. The record passed to
+// Write is reused after the call returns, so sinks that keep records must
+// copy them. Close flushes any buffered output and must be called once all
+// records are written.
+type RecordSink interface {
+	Write(r *JerkRecord) error
+	Close() error
+}
+
+// SinkError marks a failure to write records to the sink, as opposed to a
+// failure reading the input
+type SinkError struct {
+	Err error
+}
+
+func (e *SinkError) Error() string { return "writing output: " + e.Err.Error() }
+func (e *SinkError) Unwrap() error { return e.Err }
+
+// ErrTooManyParseErrors is returned when more lines failed to parse than
+// Config.MaxErrors allows
+var ErrTooManyParseErrors = errors.New("too many lines failed to parse")
+
+// Stats counts what happened while processing
+type Stats struct {
+	Lines         int // non-empty input lines read
+	ParseErrors   int // lines that were not valid JSON
+	Frames        int // frames passed to ProcessFrame
+	SkippedFrames int // frames dropped by the session, time, or EveryNth filter
+	Oversized     int // frames dropped for having more than MaxPlayers players
+	Records       int // records written to the sink
+	OutOfOrder    int // samples dropped by DropOutOfOrder
+	Duplicates    int // samples skipped by CollapseDuplicates
+	ShortDt       int // samples closer than MinDt to the previous one, clamped or skipped
+	BelowJerk     int // records dropped by MinJerk
+	NonFinite     int // records whose jerk was NaN or infinite
+	Seeded        int // records suppressed by SeedFrames
+	Sessions      int // distinct sessions with at least one player sample
+	Players       int // distinct players, counted once per session
+}
+
+// Add accumulates the counters from other, such as those of a processor
+// that handled a different input
+func (s *Stats) Add(other Stats) {
+	s.Lines += other.Lines
+	s.ParseErrors += other.ParseErrors
+	s.Frames += other.Frames
+	s.SkippedFrames += other.SkippedFrames
+	s.Oversized += other.Oversized
+	s.Records += other.Records
+	s.OutOfOrder += other.OutOfOrder
+	s.Duplicates += other.Duplicates
+	s.ShortDt += other.ShortDt
+	s.BelowJerk += other.BelowJerk
+	s.NonFinite += other.NonFinite
+	s.Seeded += other.Seeded
+	s.Sessions += other.Sessions
+	s.Players += other.Players
+}
+
+// clockResetThreshold is how far, in seconds, a player's clock must jump
+// backward to be treated as a session restart rather than an out-of-order
+// frame when out-of-order frames are being dropped
+const clockResetThreshold = 1.0
+
+// Processor carries per-player state across every input stream
+type Processor struct {
+	cfg    Config
+	states map[PlayerKey]*PlayerState
+	sink   RecordSink
+	stats  Stats
+
+	// record is handed to the sink, which avoids allocating one per write
+	record JerkRecord
+
+	resampler *resampler // nil unless resampling
+
+	// seen counts each session's frames for EveryNth
+	seen map[string]int
+	// seeding is set while a frame within SeedFrames is processed
+	seeding bool
+
+	contacts *contactDetector    // nil unless detecting contacts
+	central  *centralDifferences // nil unless using central differences
+
+	// scaled and scaledDisc hold the frame converted by UnitsScale, reused
+	// across frames
+	scaled     EchoVRFrame
+	scaledDisc Disc
+}
+
+// NewProcessor returns a processor that writes records to sink. The sink is
+// not closed by the processor.
+func NewProcessor(cfg Config, sink RecordSink) *Processor {
+	p := &Processor{
+		cfg:    cfg,
+		states: make(map[PlayerKey]*PlayerState),
+		sink:   sink,
+		seen:   make(map[string]int),
+	}
+	if cfg.ResampleHz > 0 {
+		p.resampler = newResampler(cfg.ResampleHz, cfg.ResampleMaxGap)
+	}
+	if cfg.Diff == DiffCentral {
+		p.central = newCentralDifferences(cfg.Gravity)
+	}
+	if cfg.ContactRadius > 0 && cfg.OnContact != nil {
+		p.contacts = newContactDetector(cfg.ContactRadius)
+	}
+	return p
+}
+
+// ProcessFrames reads JSON lines from r with the default configuration and
+// writes the resulting records to w
+func ProcessFrames(r io.Reader, w RecordSink) error {
+	return NewProcessor(DefaultConfig(), w).ProcessStream(r)
+}
+
+// ProcessWithContext reads JSON lines from r with cfg and writes the
+// resulting records to w until EOF or until ctx is done, in which case the
+// context's error is returned. w is closed before returning in every case,
+// so a partially written output is still finalized.
+func ProcessWithContext(ctx context.Context, r io.Reader, w RecordSink, cfg Config) error {
+	p := NewProcessor(cfg, w)
+	err := p.ProcessStreamContext(ctx, r)
+	if ferr := p.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Stats returns the counters accumulated so far
+func (p *Processor) Stats() Stats {
+	stats := p.stats
+	sessions := make(map[string]bool)
+	for key := range p.states {
+		sessions[key.SessionID] = true
+	}
+	stats.Sessions, stats.Players = len(sessions), len(p.states)
+	return stats
+}
+
+// Done reports whether the processor has written Config.Limit records and
+// will not write any more
+func (p *Processor) Done() bool {
+	return p.cfg.Limit > 0 && p.stats.Records >= p.cfg.Limit
+}
+
+// Frames with many players and full arena metadata easily exceed the
+// scanner's 64KB default token size
+const (
+	initialLineBuffer = 1024 * 1024
+	maxLineSize       = 16 * 1024 * 1024
+)
+
+// contextCheckInterval is how many lines are read between checks for
+// cancellation
+const contextCheckInterval = 256
+
+// ProcessStream reads JSON lines from r until EOF
+func (p *Processor) ProcessStream(r io.Reader) error {
+	return p.ProcessStreamContext(context.Background(), r)
+}
+
+// ProcessStreamContext reads JSON lines from r until EOF, returning the
+// context's error early if ctx is done first. Input whose first
+// non-whitespace byte is [ is read as a single JSON array of frames instead,
+// one element at a time.
+func (p *Processor) ProcessStreamContext(ctx context.Context, r io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	if startsArray(br) {
+		return p.processArray(ctx, br)
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, initialLineBuffer), maxLineSize)
+
+	// The scanner rather than a json.Decoder keeps line numbers for error
+	// reports and lets a malformed line be skipped without losing sync. One
+	// frame is reused across lines so its slices are only allocated once.
+	var frame EchoVRFrame
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if lineNum%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if err := p.processLine(lineNum, line, &frame); err != nil {
+			return err
+		}
+		if p.Done() {
+			// Nothing more will be written, so skip the rest of the input
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d exceeds the %d byte limit: %w", lineNum+1, maxLineSize, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// startsArray reports whether the first non-whitespace byte buffered by br
+// opens a JSON array, without consuming anything. Input with more leading
+// whitespace than fits in the buffer is treated as JSON lines.
+func startsArray(br *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if err != nil {
+			return false
+		}
+		switch b[n-1] {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return b[n-1] == '['
+		}
+	}
+}
+
+// processArray reads frames from a top-level JSON array. Elements are
+// decoded one at a time, so the array is never held in memory, and are
+// numbered from 1 in place of lines. An element that is valid JSON but not a
+// valid frame is skipped like a malformed line, but malformed JSON ends the
+// stream, since the decoder cannot find the next element.
+func (p *Processor) processArray(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	var frame EchoVRFrame
+	var raw json.RawMessage
+	elem := 0
+	for dec.More() {
+		elem++
+		if elem%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("array element %d: %w", elem, err)
+		}
+		if err := p.processLine(elem, raw, &frame); err != nil {
+			return err
+		}
+		if p.Done() {
+			return nil
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("unterminated JSON array after element %d: %w", elem, err)
+	}
+	return nil
+}
+
+// processLine decodes one frame from line, reusing frame, and processes it.
+// A line that fails to decode is counted and reported rather than returned,
+// unless it exceeds MaxErrors.
+func (p *Processor) processLine(lineNum int, line []byte, frame *EchoVRFrame) error {
+	p.stats.Lines++
+	if p.cfg.OnProgress != nil && p.cfg.ProgressEvery > 0 && p.stats.Lines%p.cfg.ProgressEvery == 0 {
+		p.cfg.OnProgress(p.stats)
+	}
+	frame.reset()
+	err := decodeFrame(p.cfg.Schema, line, frame)
+	if err == nil && p.cfg.Strict {
+		err = validateFrame(p.cfg.Schema, line)
+	}
+	if err != nil {
+		p.stats.ParseErrors++
+		if p.cfg.OnParseError != nil {
+			p.cfg.OnParseError(lineNum, err)
+		}
+		if p.cfg.MaxErrors > 0 && p.stats.ParseErrors > p.cfg.MaxErrors {
+			return fmt.Errorf("%w: %d of %d lines, limit %d", ErrTooManyParseErrors,
+				p.stats.ParseErrors, p.stats.Lines, p.cfg.MaxErrors)
+		}
+		return nil
+	}
+	return p.ProcessFrame(*frame)
+}
+
+// ProcessFrame updates player state from a single frame and writes the
+// resulting records to the sink
+func (p *Processor) ProcessFrame(frame EchoVRFrame) error {
+	p.stats.Frames++
+	p.seeding = p.stats.Frames <= p.cfg.SeedFrames
+	if p.cfg.MaxPlayers > 0 && playerCount(frame) > p.cfg.MaxPlayers {
+		p.stats.Oversized++
+		return nil
+	}
+	if len(p.cfg.Sessions) > 0 && !p.cfg.Sessions[frame.SessionID] ||
+		p.cfg.Window != nil && !p.cfg.Window.Contains(frame.Time) {
+		p.stats.SkippedFrames++
+		return nil
+	}
+	if p.cfg.OnFrame != nil && !p.seeding {
+		p.cfg.OnFrame(frame)
+	}
+	if p.cfg.EveryNth > 1 {
+		n := p.seen[frame.SessionID]
+		p.seen[frame.SessionID] = n + 1
+		if n%p.cfg.EveryNth != 0 {
+			p.stats.SkippedFrames++
+			return nil
+		}
+	}
+	if p.cfg.UnitsScale != 0 && p.cfg.UnitsScale != 1 {
+		frame.scaleInto(&p.scaled, &p.scaledDisc, p.cfg.UnitsScale)
+		frame = p.scaled
+	}
+	if p.resampler != nil {
+		return p.resampler.next(frame, p.processFrame)
+	}
+	return p.processFrame(frame)
+}
+
+// processFrame computes the records of every selected player in frame
+func (p *Processor) processFrame(frame EchoVRFrame) error {
+	if p.contacts != nil && !p.Done() {
+		emit := p.cfg.OnContact
+		if p.seeding {
+			// Contacts already under way once seeding ends stay unreported
+			emit = discardContact
+		}
+		if err := p.contacts.detect(frame, emit); err != nil {
+			return &SinkError{err}
+		}
+	}
+	players := int32(playerCount(frame))
+
+	// Process each player in each team
+	for ti, team := range frame.Teams {
+		centroid, hasCentroid := teamCentroid(team)
+		for pi, player := range team.Players {
+			if p.Done() {
+				return nil
+			}
+			if len(p.cfg.Users) > 0 && !p.cfg.Users[player.UserID] {
+				continue
+			}
+
+			record, ok := p.updatePlayer(frame, player, int32(ti))
+			if !ok {
+				continue
+			}
+			record.PlayerCount = players
+			record.seed = p.seeding
+			record.NearestOpponentDist = nearestOpponentDist(frame.Teams, ti, player.Position)
+			record.NearestTeammateDist = nearestTeammateDist(team, pi, player.Position)
+			record.DistToTeamCentroid = math.NaN()
+			if hasCentroid {
+				record.DistToTeamCentroid = player.Position.Distance(centroid)
+			}
+			record.DistToDisc = math.NaN()
+			record.ApproachSpeedToDisc = math.NaN()
+			if frame.Disc != nil {
+				record.DistToDisc = player.Position.Distance(frame.Disc.Position)
+				if dir, ok := frame.Disc.Position.Sub(player.Position).Normalize(); ok {
+					record.ApproachSpeedToDisc = player.Velocity.Dot(dir)
+				}
+			}
+
+			if p.central != nil {
+				// The record waits for the player's next sample
+				key := PlayerKey{SessionID: frame.SessionID, UserID: player.UserID}
+				state := p.states[key]
+				if record, ok = p.central.next(key, record, state.LastVelocity, state.lastDt); !ok {
+					continue
+				}
+			}
+			if err := p.emit(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// emit applies the record filters and writes record to the sink
+func (p *Processor) emit(record JerkRecord) error {
+	if record.seed {
+		p.stats.Seeded++
+		return nil
+	}
+	if record.JerkValid && (math.IsNaN(record.Jerk) || math.IsInf(record.Jerk, 0)) {
+		// Corrupt input reached the derivatives
+		p.stats.NonFinite++
+		if p.cfg.NaNPolicy == NaNSkip {
+			return nil
+		}
+		record.Jerk, record.JerkValid = math.NaN(), false
+		record.JerkX, record.JerkY, record.JerkZ = math.NaN(), math.NaN(), math.NaN()
+	}
+	// An undefined jerk never meets a positive threshold
+	if p.cfg.MinJerk > 0 && !(record.Jerk >= p.cfg.MinJerk) {
+		p.stats.BelowJerk++
+		return nil
+	}
+
+	p.record = record
+	if err := p.sink.Write(&p.record); err != nil {
+		return &SinkError{err}
+	}
+	p.stats.Records++
+	return nil
+}
+
+// Flush writes the records still held back for central differences, which
+// keep their forward-difference derivatives. Call it once all input has
+// been processed; it does nothing with forward differences.
+func (p *Processor) Flush() error {
+	if p.central == nil {
+		return nil
+	}
+	for _, record := range p.central.drain() {
+		if p.Done() {
+			break
+		}
+		if err := p.emit(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// playerCount returns the number of players on every team of frame
+func playerCount(frame EchoVRFrame) int {
+	n := 0
+	for _, team := range frame.Teams {
+		n += len(team.Players)
+	}
+	return n
+}
+
+// rejoinGap reports whether a gap between a player's samples is too long
+// to compute derivatives across, either because the player was missing for
+// longer than RejoinGap or because resampling skipped the span
+func (cfg *Config) rejoinGap(gap float64) bool {
+	if cfg.RejoinGap > 0 && gap > cfg.RejoinGap {
+		return true
+	}
+	if cfg.ResampleHz <= 0 {
+		return false
+	}
+	// Consecutive grid samples are one grid step apart even when that step
+	// is longer than the widest span the resampler interpolates across
+	step := 1 / cfg.ResampleHz
+	return gap > math.Max(cfg.ResampleMaxGap, step)+gridEpsilon*step
+}
+
+// nearestOpponentDist returns the distance from pos to the closest player on
+// any team other than teams[own], or NaN when there are no opponents, such as
+// in a frame with fewer than two teams
+func nearestOpponentDist(teams []Team, own int, pos Vec3) float64 {
+	nearest := math.NaN()
+	for ti, team := range teams {
+		if ti == own {
+			continue
+		}
+		for _, other := range team.Players {
+			if d := pos.Distance(other.Position); math.IsNaN(nearest) || d < nearest {
+				nearest = d
+			}
+		}
+	}
+	return nearest
+}
+
+// teamCentroid returns the average position of the team's players, or false
+// for a team without players
+func teamCentroid(team Team) (Vec3, bool) {
+	if len(team.Players) == 0 {
+		return Vec3{}, false
+	}
+	var sum Vec3
+	for _, player := range team.Players {
+		sum = sum.Add(player.Position)
+	}
+	return sum.Scale(1 / float64(len(team.Players))), true
+}
+
+// nearestTeammateDist returns the distance from the player at index self to
+// the closest other player on the same team, or NaN for a solo player
+func nearestTeammateDist(team Team, self int, pos Vec3) float64 {
+	nearest := math.NaN()
+	for pi, other := range team.Players {
+		if pi == self {
+			continue
+		}
+		if d := pos.Distance(other.Position); math.IsNaN(nearest) || d < nearest {
+			nearest = d
+		}
+	}
+	return nearest
+}
+
+// updatePlayer advances a player's state with a sample from frame, in
+// which they are on team, and returns the resulting record, or false when
+// the sample was dropped
+func (p *Processor) updatePlayer(frame EchoVRFrame, player Player, team int32) (JerkRecord, bool) {
+	key := PlayerKey{SessionID: frame.SessionID, UserID: player.UserID}
+	state, exists := p.states[key]
+	if !exists {
+		state = &PlayerState{window: newVelocityWindow(p.cfg.SmoothWindow)}
+		p.states[key] = state
+	}
+
+	record, result := advanceState(state, player, frame.Time, &p.cfg)
+	switch result {
+	case sampleDuplicate:
+		p.stats.Duplicates++
+		return record, false
+	case sampleOutOfOrder:
+		p.stats.OutOfOrder++
+		return record, false
+	case sampleTooSoon:
+		p.stats.ShortDt++
+		return record, false
+	case sampleClamped:
+		p.stats.ShortDt++
+	}
+	record.SessionID = frame.SessionID
+	record.RealTime = frame.RealTime.Time
+	record.TeamIndex = team
+
+	// Team-relative features come from each frame alone, so a player who
+	// switched teams only needs the transition marked
+	record.TeamChanged = state.HasTeam && state.LastTeam != team
+	state.LastTeam, state.HasTeam = team, true
+	return record, true
+}
+
+// ProcessPlayerFrame advances prev with player's sample at time t, like a
+// Processor does for each player of each frame, and returns the resulting
+// record. It reports false when, per cfg, the sample is a duplicate, out
+// of order, or too soon after the previous one and must be dropped; prev
+// is then left as it was. Otherwise prev now holds the sample, so the next
+// call differences against it. Pass a zero PlayerState for a player's
+// first sample, and the same cfg on every call. The record's session,
+// wall-clock time, and frame-level features such as team index and
+// proximity are left for the caller to fill in. Records are not filtered
+// by cfg.MinJerk or cfg.NaNPolicy.
+func ProcessPlayerFrame(prev *PlayerState, player Player, t float64, cfg Config) (JerkRecord, bool) {
+	if prev.window.size == 0 {
+		prev.window = newVelocityWindow(cfg.SmoothWindow)
+	}
+	record, result := advanceState(prev, player, t, &cfg)
+	return record, result == sampleRecorded || result == sampleClamped
+}
+
+// minCurvatureSpeed is the speed in m/s below which curvature is left
+// undefined, since dividing by the cubed speed blows up noise into huge
+// values as a player comes to rest
+const minCurvatureSpeed = 0.1
+
+// curvature returns how sharply a path with velocity v and acceleration a
+// bends, |v × a| / |v|³, in 1/m, or NaN below minCurvatureSpeed
+func curvature(v, a Vec3) float64 {
+	speed := v.Magnitude()
+	if !(speed >= minCurvatureSpeed) {
+		return math.NaN()
+	}
+	return v.Cross(a).Magnitude() / (speed * speed * speed)
+}
+
+// sampleResult says what advanceState did with a sample
+type sampleResult int
+
+const (
+	sampleRecorded sampleResult = iota
+	sampleClamped               // recorded with its time step raised to MinDt
+	sampleDuplicate
+	sampleOutOfOrder
+	sampleTooSoon // dropped for a time step below MinDt
+)
+
+// advanceState advances state with player's sample at time t and returns
+// the resulting record. state has seen no samples while its FrameIndex is 0.
+func advanceState(state *PlayerState, player Player, t float64, cfg *Config) (JerkRecord, sampleResult) {
+	exists := state.FrameIndex > 0
+	// Speed needs no history, so every observed frame produces a
+	// record; accel and jerk stay NaN until enough history exists.
+	record := JerkRecord{
+		UserID:      player.UserID,
+		DisplayName: player.DisplayName,
+		Role:        player.Role,
+		Time:        t,
+		Speed:       player.Velocity.Magnitude(),
+		PosX:        player.Position.X,
+		PosY:        player.Position.Y,
+		PosZ:        player.Position.Z,
+		VelX:        player.Velocity.X,
+		VelY:        player.Velocity.Y,
+		VelZ:        player.Velocity.Z,
+		Accel:       math.NaN(),
+		AccelComp:   math.NaN(),
+		DeltaTime:   math.NaN(),
+		Jerk:        math.NaN(),
+		JerkX:       math.NaN(),
+		JerkY:       math.NaN(),
+		JerkZ:       math.NaN(),
+		Snap:        math.NaN(),
+
+		AccelAlignment:    math.NaN(),
+		Curvature:         math.NaN(),
+		AngularVelocity:   math.NaN(),
+		HeadingChangeRate: math.NaN(),
+	}
+
+	if exists && cfg.CollapseDuplicates && state.isDuplicate(t, player) {
+		// EchoVR sometimes emits the same frame twice
+		return record, sampleDuplicate
+	}
+	if exists && cfg.DropOutOfOrder && t <= state.LastTime &&
+		state.LastTime-t <= clockResetThreshold {
+		// Stale or repeated sample; keep the existing history
+		return record, sampleOutOfOrder
+	}
+	if !exists || t < state.LastTime {
+		// Initialize state for a new player, or start over when the
+		// clock jumped backward because the session restarted
+		state.reset(player, t)
+		record.FrameIndex = state.nextFrameIndex()
+		return record, sampleRecorded
+	}
+	if cfg.rejoinGap(t - state.LastTime) {
+		// The player left and rejoined, possibly somewhere else on the map,
+		// so differencing across the gap would look like a teleport. The
+		// distance travelled and frame count so far are kept.
+		distance, frameIndex := state.Distance, state.FrameIndex
+		state.reset(player, t)
+		state.Distance, state.FrameIndex = distance, frameIndex
+		record.Distance = distance
+		record.FrameIndex = state.nextFrameIndex()
+		return record, sampleRecorded
+	}
+
+	// Time elapsed since the player's previous sample
+	dt := t - state.LastTime
+	result := sampleRecorded
+	if cfg.MinDt > 0 && !cfg.AssumeUniformDt && dt > 0 && dt < cfg.MinDt {
+		// Near-duplicate timestamps would blow the derivatives up
+		if cfg.MinDtPolicy == MinDtSkip {
+			return record, sampleTooSoon
+		}
+		dt, result = cfg.MinDt, sampleClamped
+	}
+	record.FrameIndex = state.nextFrameIndex()
+
+	if cfg.AssumeUniformDt {
+		dt = 1
+	} else if dt <= 0 {
+		// The clock did not advance, so the derivative is undefined
+		record.Distance = state.Distance
+		state.lastDt = 0
+		return record, sampleRecorded
+	}
+
+	record.DeltaTime = dt
+	step := player.Position.Distance(state.LastPosition)
+	state.Distance += step
+	record.Distance = state.Distance
+	if cfg.MaxSpeed > 0 && step/dt > cfg.MaxSpeed {
+		// Faster than a player can move, so the position jumped
+		record.Suspect = true
+	}
+
+	// Rotation rate of the forward vector in radians per second
+	record.AngularVelocity = angleBetween(state.LastForward, player.Forward) / dt
+
+	state.window.push(player.Velocity)
+	velocity := state.window.mean()
+
+	// Turn rate of the movement direction, which is undefined while the
+	// player is stationary
+	if from, ok := state.LastVelocity.Normalize(); ok {
+		if to, ok := velocity.Normalize(); ok {
+			record.HeadingChangeRate = math.Acos(math.Max(-1, math.Min(1, from.Dot(to)))) / dt
+		}
+	}
+
+	// Calculate acceleration from the change in (smoothed) velocity over time
+	currentAccel := velocity.Sub(state.LastVelocity).Scale(1 / dt)
+	record.Accel = currentAccel.Magnitude()
+	if cfg.Gravity != nil {
+		record.AccelComp = currentAccel.Sub(*cfg.Gravity).Magnitude()
+	}
+
+	// Cosine between acceleration and movement direction: positive while
+	// speeding up, negative while braking
+	if a, ok := currentAccel.Normalize(); ok {
+		if v, ok := velocity.Normalize(); ok {
+			record.AccelAlignment = a.Dot(v)
+		}
+	}
+	record.Curvature = curvature(velocity, currentAccel)
+
+	if state.HasPrevious {
+		// Calculate jerk as the change in acceleration over time
+		jerk := currentAccel.Sub(state.LastAccel).Scale(1 / dt)
+		record.Jerk = jerk.Magnitude()
+		record.JerkValid = true
+		record.JerkX, record.JerkY, record.JerkZ = jerk.X, jerk.Y, jerk.Z
+
+		if state.HasJerk {
+			// Snap is the change in jerk over time
+			record.Snap = jerk.Sub(state.LastJerk).Magnitude() / dt
+		}
+		state.LastJerk = jerk
+		state.HasJerk = true
+	}
+
+	// Update state
+	state.LastPosition = player.Position
+	state.LastVelocity = velocity
+	state.LastForward = player.Forward
+	state.LastAccel = currentAccel
+	state.LastTime = t
+	state.HasPrevious = true
+	state.lastDt = dt
+	return record, result
+}
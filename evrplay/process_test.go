@@ -0,0 +1,445 @@
+package evrplay
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// collectSink keeps a copy of every record written to it
+type collectSink struct {
+	records []JerkRecord
+}
+
+func (s *collectSink) Write(r *JerkRecord) error {
+	s.records = append(s.records, *r)
+	return nil
+}
+
+func (s *collectSink) Close() error { return nil }
+
+// singlePlayerFrame returns a frame of session "s" at time t holding one
+// player on one team
+func singlePlayerFrame(t float64, player Player) EchoVRFrame {
+	return EchoVRFrame{SessionID: "s", Time: t, Teams: []Team{{Players: []Player{player}}}}
+}
+
+// runFrames feeds frames through a processor with cfg, flushes it, and
+// returns the records written
+func runFrames(t *testing.T, cfg Config, frames []EchoVRFrame) []JerkRecord {
+	t.Helper()
+	sink := &collectSink{}
+	p := NewProcessor(cfg, sink)
+	for _, frame := range frames {
+		if err := p.ProcessFrame(frame); err != nil {
+			t.Fatalf("ProcessFrame(t=%v): %v", frame.Time, err)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	return sink.records
+}
+
+func approxEqual(a, b, eps float64) bool {
+	return math.Abs(a-b) <= eps
+}
+
+func TestCentralDifferencesUnevenGrid(t *testing.T) {
+	// v = t² along x, so the acceleration is 2t and the jerk 2 everywhere;
+	// a central stencil is exact on a quadratic whatever the step sizes
+	steps := []float64{0.1, 0.05, 0.2, 0.05, 0.3}
+	times := []float64{0}
+	for _, h := range steps {
+		times = append(times, times[len(times)-1]+h)
+	}
+	var frames []EchoVRFrame
+	for _, tm := range times {
+		frames = append(frames, singlePlayerFrame(tm, Player{UserID: "a", Velocity: Vec3{X: tm * tm}}))
+	}
+
+	cfg := DefaultConfig()
+	cfg.Diff = DiffCentral
+	gravity := Vec3{Y: -9.81}
+	cfg.Gravity = &gravity
+	records := runFrames(t, cfg, frames)
+	if len(records) != len(times) {
+		t.Fatalf("got %d records, want %d", len(records), len(times))
+	}
+
+	// The first and last samples have no centered stencil
+	for i := 1; i < len(times)-1; i++ {
+		r := records[i]
+		tm := times[i]
+		if !approxEqual(r.Time, tm, 1e-12) {
+			t.Fatalf("record %d has time %v, want %v", i, r.Time, tm)
+		}
+		if want := 2 * tm; !approxEqual(r.Accel, want, 1e-9) {
+			t.Errorf("t=%v: accel = %v, want %v", tm, r.Accel, want)
+		}
+		if !r.JerkValid || !approxEqual(r.Jerk, 2, 1e-9) {
+			t.Errorf("t=%v: jerk = %v (valid %v), want 2", tm, r.Jerk, r.JerkValid)
+		}
+		if want := (Vec3{X: 2 * tm}).Sub(gravity).Magnitude(); !approxEqual(r.AccelComp, want, 1e-9) {
+			t.Errorf("t=%v: accel_compensated = %v, want %v", tm, r.AccelComp, want)
+		}
+		if !approxEqual(r.AccelAlignment, 1, 1e-12) {
+			t.Errorf("t=%v: accel_alignment = %v, want 1", tm, r.AccelAlignment)
+		}
+	}
+}
+
+func TestProcessStreamLongLine(t *testing.T) {
+	// A padding field pushes every line well past the scanner's 64KB
+	// default; the fields are unknown to the frame, so they are ignored
+	padding := strings.Repeat("x", 100*1024)
+	var input strings.Builder
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&input, `{"sessionid":"s","game_clock":%v,"padding":%q,"teams":[{"players":[`, float64(i)/10, padding)
+		for j := 0; j < 4; j++ {
+			if j > 0 {
+				input.WriteString(",")
+			}
+			fmt.Fprintf(&input, `{"userid":"u%d","position":[%d,0,%d],"velocity":[%d,0,0]}`, j, j, i, i*j)
+		}
+		input.WriteString("]}]}\n")
+	}
+
+	sink := &collectSink{}
+	p := NewProcessor(DefaultConfig(), sink)
+	if err := p.ProcessStream(strings.NewReader(input.String())); err != nil {
+		t.Fatalf("ProcessStream: %v", err)
+	}
+	stats := p.Stats()
+	if stats.Lines != 3 || stats.ParseErrors != 0 {
+		t.Errorf("read %d lines with %d parse errors, want 3 and 0", stats.Lines, stats.ParseErrors)
+	}
+	if len(sink.records) != 12 {
+		t.Errorf("got %d records, want 12", len(sink.records))
+	}
+}
+
+func TestProcessStreamLineTooLong(t *testing.T) {
+	line := `{"sessionid":"s","padding":"` + strings.Repeat("x", maxLineSize) + `"}` + "\n"
+	input := io.MultiReader(strings.NewReader(`{"sessionid":"s","game_clock":0}`+"\n"), strings.NewReader(line))
+	err := NewProcessor(DefaultConfig(), &collectSink{}).ProcessStream(input)
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("ProcessStream = %v, want %v", err, bufio.ErrTooLong)
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error %q does not name line 2", err)
+	}
+}
+
+func TestProcessClockReset(t *testing.T) {
+	// A steady acceleration of 10 until the clock restarts from 0 with the
+	// player much faster; differencing across the restart would divide a
+	// large velocity change by a negative time step
+	var frames []EchoVRFrame
+	for _, tm := range []float64{0, 0.1, 0.2, 0.3} {
+		frames = append(frames, singlePlayerFrame(tm, Player{UserID: "a", Velocity: Vec3{X: 10 * tm}}))
+	}
+	for _, tm := range []float64{0, 0.1, 0.2} {
+		frames = append(frames, singlePlayerFrame(tm, Player{UserID: "a", Velocity: Vec3{X: 50 + 10*tm}}))
+	}
+
+	records := runFrames(t, DefaultConfig(), frames)
+	if len(records) != len(frames) {
+		t.Fatalf("got %d records, want %d", len(records), len(frames))
+	}
+	if !records[3].JerkValid {
+		t.Fatalf("record before the reset has no jerk")
+	}
+
+	reset := records[4]
+	if reset.FrameIndex != 0 || !math.IsNaN(reset.Accel) || reset.JerkValid {
+		t.Errorf("record at the reset has frame index %d, accel %v, jerk valid %v; want a fresh history",
+			reset.FrameIndex, reset.Accel, reset.JerkValid)
+	}
+	for _, r := range records[5:] {
+		if !approxEqual(r.Accel, 10, 1e-9) {
+			t.Errorf("t=%v after the reset: accel = %v, want 10", r.Time, r.Accel)
+		}
+	}
+	if last := records[6]; !last.JerkValid || !approxEqual(last.Jerk, 0, 1e-6) {
+		t.Errorf("jerk after the reset = %v (valid %v), want 0", last.Jerk, last.JerkValid)
+	}
+}
+
+// frameStream generates n frames of one session as JSON lines without ever
+// holding more than one frame in memory
+type frameStream struct {
+	n, i    int
+	players int
+	buf     []byte
+}
+
+func (s *frameStream) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.i == s.n {
+			return 0, io.EOF
+		}
+		tm := float64(s.i) / 60
+		s.buf = fmt.Appendf(s.buf[:0], `{"sessionid":"s","game_clock":%v,"teams":[{"players":[`, tm)
+		for j := 0; j < s.players; j++ {
+			if j > 0 {
+				s.buf = append(s.buf, ',')
+			}
+			a := tm + float64(j)
+			s.buf = fmt.Appendf(s.buf, `{"userid":"u%d","position":[%v,1,%v],"velocity":[%v,0,%v]}`,
+				j, math.Cos(a), math.Sin(a), -math.Sin(a), math.Cos(a))
+		}
+		s.buf = append(s.buf, "]}]}\n"...)
+		s.i++
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// heapSink discards records, sampling the live heap after the first
+// sampleAt of them and again after the last of total
+type heapSink struct {
+	count, sampleAt, total int
+	early, late            uint64
+}
+
+func (s *heapSink) Write(r *JerkRecord) error {
+	s.count++
+	switch s.count {
+	case s.sampleAt:
+		s.early = liveHeap()
+	case s.total:
+		s.late = liveHeap()
+	}
+	return nil
+}
+
+func (s *heapSink) Close() error { return nil }
+
+func liveHeap() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func TestProcessStreamConstantMemory(t *testing.T) {
+	const frames, players = 50000, 4
+	sink := &heapSink{sampleAt: 5000 * players, total: frames * players}
+	if err := NewProcessor(DefaultConfig(), sink).ProcessStream(&frameStream{n: frames, players: players}); err != nil {
+		t.Fatal(err)
+	}
+	if sink.count != frames*players {
+		t.Fatalf("got %d records, want %d", sink.count, frames*players)
+	}
+
+	// Ten times the input must not grow the heap past noise
+	if sink.late > sink.early && sink.late-sink.early > 1<<20 {
+		t.Errorf("live heap grew from %d to %d bytes over the stream", sink.early, sink.late)
+	}
+}
+
+func TestProcessRejoinAfterGap(t *testing.T) {
+	frames := []EchoVRFrame{
+		singlePlayerFrame(0, Player{UserID: "a", Position: Vec3{X: 0}, Velocity: Vec3{X: 1}}),
+		singlePlayerFrame(0.1, Player{UserID: "a", Position: Vec3{X: 0.1}, Velocity: Vec3{X: 1}}),
+		singlePlayerFrame(0.2, Player{UserID: "a", Position: Vec3{X: 0.2}, Velocity: Vec3{X: 1}}),
+		// Gone for ten seconds, then back on the far side of the arena
+		singlePlayerFrame(10.2, Player{UserID: "a", Position: Vec3{X: 80, Z: 20}, Velocity: Vec3{Z: -3}}),
+		singlePlayerFrame(10.3, Player{UserID: "a", Position: Vec3{X: 80, Z: 19.7}, Velocity: Vec3{Z: -3}}),
+	}
+
+	cfg := DefaultConfig()
+	cfg.RejoinGap = 2
+	cfg.MaxSpeed = 20
+	records := runFrames(t, cfg, frames)
+	if len(records) != len(frames) {
+		t.Fatalf("got %d records, want %d", len(records), len(frames))
+	}
+	rejoin := records[3]
+	if !math.IsNaN(rejoin.Accel) || rejoin.JerkValid || rejoin.Suspect {
+		t.Errorf("rejoin has accel %v, jerk valid %v, suspect %v; want a fresh history",
+			rejoin.Accel, rejoin.JerkValid, rejoin.Suspect)
+	}
+	if rejoin.FrameIndex != 3 || !approxEqual(rejoin.Distance, 0.2, 1e-12) {
+		t.Errorf("rejoin has frame index %d and distance %v, want 3 and 0.2", rejoin.FrameIndex, rejoin.Distance)
+	}
+	if after := records[4]; !approxEqual(after.Accel, 0, 1e-9) || !approxEqual(after.Distance, 0.5, 1e-9) {
+		t.Errorf("after the rejoin: accel %v and distance %v, want 0 and 0.5", after.Accel, after.Distance)
+	}
+
+	// Without a rejoin gap the jump is differenced like any other step
+	records = runFrames(t, DefaultConfig(), frames)
+	if math.IsNaN(records[3].Accel) {
+		t.Errorf("accel across the gap is NaN without a rejoin gap")
+	}
+}
+
+func TestProcessDuplicateFrame(t *testing.T) {
+	frame := func(tm float64) EchoVRFrame {
+		return singlePlayerFrame(tm, Player{UserID: "a", Position: Vec3{X: tm}, Velocity: Vec3{X: 1 + tm}})
+	}
+	frames := []EchoVRFrame{frame(0), frame(0.1), frame(0.1), frame(0.2), frame(0.3)}
+
+	sink := &collectSink{}
+	p := NewProcessor(DefaultConfig(), sink)
+	for _, f := range frames {
+		if err := p.ProcessFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := p.Stats().Duplicates; got != 1 {
+		t.Errorf("collapsed %d duplicates, want 1", got)
+	}
+	if len(sink.records) != 4 {
+		t.Fatalf("got %d records, want 4", len(sink.records))
+	}
+	for _, r := range sink.records[1:] {
+		if !approxEqual(r.Accel, 1, 1e-9) {
+			t.Errorf("t=%v: accel = %v, want 1", r.Time, r.Accel)
+		}
+	}
+	if last := sink.records[3]; !last.JerkValid || !approxEqual(last.Jerk, 0, 1e-6) {
+		t.Errorf("jerk after the duplicate = %v (valid %v), want 0", last.Jerk, last.JerkValid)
+	}
+
+	// Kept, the duplicate has no time step to differentiate over
+	cfg := DefaultConfig()
+	cfg.CollapseDuplicates = false
+	records := runFrames(t, cfg, frames)
+	if len(records) != len(frames) {
+		t.Fatalf("got %d records without collapsing, want %d", len(records), len(frames))
+	}
+	if !math.IsNaN(records[2].Accel) {
+		t.Errorf("kept duplicate has accel %v, want NaN", records[2].Accel)
+	}
+}
+
+func TestProcessTeamSwap(t *testing.T) {
+	// Player a moves from team 0 to team 1 at t=0.3 while b stays put
+	var frames []EchoVRFrame
+	for i, tm := range []float64{0, 0.1, 0.2, 0.3, 0.4} {
+		a := Player{UserID: "a", Position: Vec3{X: tm}, Velocity: Vec3{X: 2 * tm}}
+		b := Player{UserID: "b", Position: Vec3{Z: 5}}
+		teams := []Team{{Players: []Player{a}}, {Players: []Player{b}}}
+		if i >= 3 {
+			teams = []Team{{}, {Players: []Player{b, a}}}
+		}
+		frames = append(frames, EchoVRFrame{SessionID: "s", Time: tm, Teams: teams})
+	}
+
+	cfg := DefaultConfig()
+	cfg.TeamSwaps = true
+	var changed []string
+	for _, r := range runFrames(t, cfg, frames) {
+		if r.UserID != "a" {
+			if r.TeamChanged {
+				t.Errorf("t=%v: %s marked as changing teams", r.Time, r.UserID)
+			}
+			continue
+		}
+		wantTeam := int32(0)
+		if r.Time >= 0.3 {
+			wantTeam = 1
+		}
+		if r.TeamIndex != wantTeam {
+			t.Errorf("t=%v: team index = %d, want %d", r.Time, r.TeamIndex, wantTeam)
+		}
+		if r.TeamChanged {
+			changed = append(changed, fmt.Sprint(r.Time))
+		}
+		// The kinematic history carries across the swap
+		if r.Time >= 0.2 && (!r.JerkValid || !approxEqual(r.Accel, 2, 1e-9)) {
+			t.Errorf("t=%v: accel = %v (jerk valid %v), want 2 across the swap", r.Time, r.Accel, r.JerkValid)
+		}
+		if r.Time >= 0.3 && !approxEqual(r.NearestTeammateDist, Vec3{X: r.Time}.Distance(Vec3{Z: 5}), 1e-9) {
+			t.Errorf("t=%v: nearest teammate at %v, want b", r.Time, r.NearestTeammateDist)
+		}
+	}
+	if len(changed) != 1 || changed[0] != "0.3" {
+		t.Errorf("team change marked at %v, want only 0.3", changed)
+	}
+}
+
+func TestProcessResampleBelowMaxGapRate(t *testing.T) {
+	// A 10 Hz capture with v = 2t, so the accel is 2 and the jerk 0. At 1
+	// and 2 Hz the grid step is longer than the default 0.25 s max gap.
+	var frames []EchoVRFrame
+	for i := 0; i <= 60; i++ {
+		tm := float64(i) / 10
+		frames = append(frames, singlePlayerFrame(tm, Player{UserID: "a", Position: Vec3{X: tm * tm}, Velocity: Vec3{X: 2 * tm}}))
+	}
+
+	for _, hz := range []float64{1, 2} {
+		cfg := DefaultConfig()
+		cfg.ResampleHz, cfg.ResampleMaxGap = hz, 0.25
+		records := runFrames(t, cfg, frames)
+		if want := int(6*hz) - 1; len(records) < want {
+			t.Fatalf("%v Hz: got %d records, want at least %d", hz, len(records), want)
+		}
+		// The first two grid samples lack the history for a jerk
+		for _, r := range records[2:] {
+			if !approxEqual(r.DeltaTime, 1/hz, 1e-9) || !approxEqual(r.Accel, 2, 1e-9) ||
+				!r.JerkValid || !approxEqual(r.Jerk, 0, 1e-9) {
+				t.Errorf("%v Hz, t=%v: delta_time %v, accel %v, jerk %v (valid %v); want %v, 2, 0",
+					hz, r.Time, r.DeltaTime, r.Accel, r.Jerk, r.JerkValid, 1/hz)
+			}
+		}
+	}
+
+	// A span wider than the max gap still starts the history over
+	cfg := DefaultConfig()
+	cfg.ResampleHz, cfg.ResampleMaxGap = 10, 0.25
+	gapped := append(append([]EchoVRFrame{}, frames[:20]...), frames[30:]...)
+	found := false
+	for _, r := range runFrames(t, cfg, gapped) {
+		if approxEqual(r.Time, 3.1, 1e-9) {
+			found = true
+			if !math.IsNaN(r.Accel) {
+				t.Errorf("first grid sample after the gap has accel %v, want NaN", r.Accel)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no grid sample at 3.1 after the gap")
+	}
+}
+
+func TestCentralDifferencesDeltaTime(t *testing.T) {
+	// v = t³ along x on an uneven grid
+	times := []float64{0, 0.1, 0.15, 0.35, 0.4, 0.7}
+	var frames []EchoVRFrame
+	for _, tm := range times {
+		frames = append(frames, singlePlayerFrame(tm, Player{UserID: "a", Velocity: Vec3{X: tm * tm * tm}}))
+	}
+	cfg := DefaultConfig()
+	cfg.Diff = DiffCentral
+	records := runFrames(t, cfg, frames)
+	if len(records) != len(times) {
+		t.Fatalf("got %d records, want %d", len(records), len(times))
+	}
+
+	last := len(times) - 1
+	if !math.IsNaN(records[0].DeltaTime) || !approxEqual(records[last].DeltaTime, times[last]-times[last-1], 1e-12) {
+		t.Errorf("boundary delta_time = %v and %v, want NaN and the step back", records[0].DeltaTime, records[last].DeltaTime)
+	}
+	for i := 1; i < last; i++ {
+		r := records[i]
+		h1, h2 := times[i]-times[i-1], times[i+1]-times[i]
+		if !approxEqual(r.DeltaTime, times[i+1]-times[i-1], 1e-12) {
+			t.Errorf("t=%v: delta_time = %v, want the stencil span %v", times[i], r.DeltaTime, h1+h2)
+		}
+		// The jerk divides the change in slope by half the span
+		v := func(j int) float64 { return math.Pow(times[j], 3) }
+		slopes := (v(i+1)-v(i))/h2 - (v(i)-v(i-1))/h1
+		if want := math.Abs(slopes / (r.DeltaTime / 2)); !approxEqual(r.Jerk, want, 1e-9) {
+			t.Errorf("t=%v: jerk = %v, want %v from delta_time", times[i], r.Jerk, want)
+		}
+	}
+}
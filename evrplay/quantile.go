@@ -0,0 +1,103 @@
+package evrplay
+
+import (
+	"math"
+	"sort"
+)
+
+// p2Quantile estimates one quantile of a stream in constant memory with the
+// P² algorithm of Jain and Chlamtac, which keeps five markers whose heights
+// track the minimum, the quantile, the maximum, and the points halfway
+// between them. The first five samples are kept exactly.
+type p2Quantile struct {
+	p       float64
+	count   int
+	q       [5]float64 // marker heights
+	n       [5]float64 // marker positions, 0-based
+	desired [5]float64 // desired marker positions
+	inc     [5]float64 // growth of the desired positions per sample
+}
+
+func newP2Quantile(p float64) p2Quantile {
+	return p2Quantile{
+		p:       p,
+		n:       [5]float64{0, 1, 2, 3, 4},
+		desired: [5]float64{0, 2 * p, 4 * p, 2 + 2*p, 4},
+		inc:     [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+// Add folds a sample into the estimate
+func (e *p2Quantile) Add(x float64) {
+	if e.count < 5 {
+		e.q[e.count] = x
+		e.count++
+		if e.count == 5 {
+			sort.Float64s(e.q[:])
+		}
+		return
+	}
+	e.count++
+
+	// Find the cell holding x, stretching the extremes to fit it
+	var k int
+	switch {
+	case x < e.q[0]:
+		e.q[0], k = x, 0
+	case x >= e.q[4]:
+		e.q[4], k = x, 3
+	default:
+		for k = 0; k < 3 && x >= e.q[k+1]; k++ {
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		e.n[i]++
+	}
+	for i := range e.desired {
+		e.desired[i] += e.inc[i]
+	}
+
+	// Move the middle markers that drifted off their desired positions
+	for i := 1; i < 4; i++ {
+		d := e.desired[i] - e.n[i]
+		if d >= 1 && e.n[i+1]-e.n[i] > 1 || d <= -1 && e.n[i-1]-e.n[i] < -1 {
+			s := math.Copysign(1, d)
+			if q := e.parabolic(i, s); e.q[i-1] < q && q < e.q[i+1] {
+				e.q[i] = q
+			} else {
+				j := i + int(s)
+				e.q[i] += s * (e.q[j] - e.q[i]) / (e.n[j] - e.n[i])
+			}
+			e.n[i] += s
+		}
+	}
+}
+
+// parabolic returns marker i's height moved s positions along the parabola
+// through it and its neighbours
+func (e *p2Quantile) parabolic(i int, s float64) float64 {
+	q, n := &e.q, &e.n
+	return q[i] + s/(n[i+1]-n[i-1])*
+		((n[i]-n[i-1]+s)*(q[i+1]-q[i])/(n[i+1]-n[i])+
+			(n[i+1]-n[i]-s)*(q[i]-q[i-1])/(n[i]-n[i-1]))
+}
+
+// Value returns the estimated quantile, which is exact, by linear
+// interpolation between the samples, for fewer than five samples, or NaN
+// without samples
+func (e *p2Quantile) Value() float64 {
+	if e.count >= 5 {
+		return e.q[2]
+	}
+	if e.count == 0 {
+		return math.NaN()
+	}
+	sorted := e.q
+	sort.Float64s(sorted[:e.count])
+	pos := e.p * float64(e.count-1)
+	lo := int(pos)
+	if lo+1 >= e.count {
+		return sorted[lo]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
@@ -0,0 +1,72 @@
+package evrplay
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestP2QuantileFewSamples(t *testing.T) {
+	e := newP2Quantile(0.5)
+	if v := e.Value(); !math.IsNaN(v) {
+		t.Errorf("Value without samples = %v, want NaN", v)
+	}
+	// Below five samples the value interpolates between the sorted samples
+	for _, x := range []float64{4, 1, 3} {
+		e.Add(x)
+	}
+	if v := e.Value(); v != 3 {
+		t.Errorf("median of 4, 1, 3 = %v, want 3", v)
+	}
+	e.Add(2)
+	if v := e.Value(); v != 2.5 {
+		t.Errorf("median of 4, 1, 3, 2 = %v, want 2.5", v)
+	}
+}
+
+// TestP2QuantileAccuracy backs the bounds the README quotes: the rank of the
+// estimate among the samples is off by at most 2.5 percentage points after
+// 1,000 samples and 0.5 after 100,000
+func TestP2QuantileAccuracy(t *testing.T) {
+	dists := []struct {
+		name   string
+		sample func(r *rand.Rand) float64
+	}{
+		{"normal", func(r *rand.Rand) float64 { return r.NormFloat64() }},
+		{"exponential", func(r *rand.Rand) float64 { return r.ExpFloat64() }},
+		{"lognormal", func(r *rand.Rand) float64 { return math.Exp(r.NormFloat64()) }},
+	}
+	sizes := []struct {
+		n, trials int
+		maxErr    float64
+	}{
+		{1000, 20, 0.025},
+		{100000, 3, 0.005},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, dist := range dists {
+		for _, size := range sizes {
+			worst := 0.0
+			samples := make([]float64, size.n)
+			for trial := 0; trial < size.trials; trial++ {
+				estimates := []p2Quantile{newP2Quantile(0.5), newP2Quantile(0.9), newP2Quantile(0.99)}
+				for i := range samples {
+					samples[i] = dist.sample(r)
+					for j := range estimates {
+						estimates[j].Add(samples[i])
+					}
+				}
+				sort.Float64s(samples)
+				for _, e := range estimates {
+					rank := float64(sort.SearchFloat64s(samples, e.Value())) / float64(size.n)
+					worst = math.Max(worst, math.Abs(rank-e.p))
+				}
+			}
+			if worst > size.maxErr {
+				t.Errorf("%s, %d samples: rank error %.4f, want at most %.4f", dist.name, size.n, worst, size.maxErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package evrplay
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// JerkRecord represents a row in the output file. Which fields are written
+// is decided by the active columns.
+type JerkRecord struct {
+	SessionID string
+	UserID    string
+	Time      float64
+	Speed     float64
+	Accel     float64
+	Jerk      float64
+	// AccelComp is the acceleration magnitude with Config.Gravity removed
+	AccelComp float64
+	JerkX     float64
+	JerkY     float64
+	JerkZ     float64
+	Snap      float64
+	Distance  float64
+
+	// PosX through VelZ are the player's raw position and velocity, which
+	// need no history
+	PosX float64
+	PosY float64
+	PosZ float64
+	VelX float64
+	VelY float64
+	VelZ float64
+
+	// RealTime is the frame's wall-clock capture time, zero when the input
+	// has none
+	RealTime time.Time
+
+	// DeltaTime is the time since the player's previous sample that the
+	// record's derivatives are normalized by, NaN when it has none. With
+	// central differences it is the span from the previous sample to the
+	// next.
+	DeltaTime float64
+	// seed marks a record computed from a frame within Config.SeedFrames
+	seed bool
+
+	// DisplayName is the player's human-readable name, empty when the input
+	// schema has none
+	DisplayName string
+
+	// Role is the player's role, such as "goalie" or "field", empty when the
+	// input schema has none
+	Role string
+
+	// TeamIndex is the player's position in the frame's teams array, which
+	// is 0 for blue and 1 for orange in the official schema
+	TeamIndex int32
+	// TeamChanged is set on the first record after the player moved to a
+	// different team within the session
+	TeamChanged bool
+	// PlayerCount is how many players the frame holds across all teams,
+	// which exposes partial frames
+	PlayerCount int32
+
+	// FrameIndex counts the player's samples, starting at 0, and restarts
+	// along with the rest of their history when the session restarts
+	FrameIndex int64
+
+	AccelAlignment    float64
+	Curvature         float64
+	AngularVelocity   float64
+	HeadingChangeRate float64
+
+	NearestOpponentDist float64
+	NearestTeammateDist float64
+	DistToTeamCentroid  float64
+	DistToDisc          float64
+
+	// ApproachSpeedToDisc is the component of the player's velocity toward
+	// the disc, positive when closing in on it
+	ApproachSpeedToDisc float64
+
+	// Suspect marks a sample whose position jumped faster than MaxSpeed
+	Suspect bool
+	// JerkValid is false while the player lacks the history to compute
+	// jerk, telling a priming frame apart from a true zero
+	JerkValid bool
+}
+
+// OutputSchemaVersion identifies the meaning of the output columns. It is
+// bumped whenever a column is renamed or its definition changes, so readers
+// can tell files written by incompatible versions apart.
+const OutputSchemaVersion = 1
+
+// Column describes a single output column and how to read it from a record
+type Column struct {
+	Name  string
+	Type  string // parquet physical type
+	Value func(r *JerkRecord) interface{}
+}
+
+func stringColumn(name string, get func(r *JerkRecord) string) Column {
+	return Column{Name: name, Type: "BYTE_ARRAY", Value: func(r *JerkRecord) interface{} { return get(r) }}
+}
+
+func doubleColumn(name string, get func(r *JerkRecord) float64) Column {
+	return Column{Name: name, Type: "DOUBLE", Value: func(r *JerkRecord) interface{} { return get(r) }}
+}
+
+func boolColumn(name string, get func(r *JerkRecord) bool) Column {
+	return Column{Name: name, Type: "BOOLEAN", Value: func(r *JerkRecord) interface{} { return get(r) }}
+}
+
+func int32Column(name string, get func(r *JerkRecord) int32) Column {
+	return Column{Name: name, Type: "INT32", Value: func(r *JerkRecord) interface{} { return get(r) }}
+}
+
+func int64Column(name string, get func(r *JerkRecord) int64) Column {
+	return Column{Name: name, Type: "INT64", Value: func(r *JerkRecord) interface{} { return get(r) }}
+}
+
+// OutputColumns returns the columns enabled by cfg, in output order
+func OutputColumns(cfg Config) []Column {
+	cols := []Column{
+		stringColumn("sessionid", func(r *JerkRecord) string { return r.SessionID }),
+		stringColumn("userid", func(r *JerkRecord) string { return r.UserID }),
+	}
+	if cfg.DisplayNames {
+		cols = append(cols, stringColumn("display_name", func(r *JerkRecord) string { return r.DisplayName }))
+	}
+	cols = append(cols,
+		int32Column("team_index", func(r *JerkRecord) int32 { return r.TeamIndex }),
+		stringColumn("role", func(r *JerkRecord) string { return r.Role }),
+		int32Column("player_count", func(r *JerkRecord) int32 { return r.PlayerCount }),
+		doubleColumn("time", func(r *JerkRecord) float64 { return r.Time }),
+		stringColumn("real_time", formatRealTime),
+		int64Column("frame_index", func(r *JerkRecord) int64 { return r.FrameIndex }),
+		doubleColumn("delta_time", func(r *JerkRecord) float64 { return r.DeltaTime }),
+		doubleColumn("speed", func(r *JerkRecord) float64 { return r.Speed }),
+		doubleColumn("accel", func(r *JerkRecord) float64 { return r.Accel }),
+		doubleColumn("accel_alignment", func(r *JerkRecord) float64 { return r.AccelAlignment }),
+		doubleColumn("curvature", func(r *JerkRecord) float64 { return r.Curvature }),
+	)
+	if cfg.Gravity != nil {
+		cols = append(cols, doubleColumn("accel_compensated", func(r *JerkRecord) float64 { return r.AccelComp }))
+	}
+	if cfg.Derivatives >= 2 {
+		cols = append(cols,
+			doubleColumn("jerk", func(r *JerkRecord) float64 { return r.Jerk }),
+			boolColumn("jerk_valid", func(r *JerkRecord) bool { return r.JerkValid }),
+		)
+		if cfg.PerAxis {
+			cols = append(cols,
+				doubleColumn("jerk_x", func(r *JerkRecord) float64 { return r.JerkX }),
+				doubleColumn("jerk_y", func(r *JerkRecord) float64 { return r.JerkY }),
+				doubleColumn("jerk_z", func(r *JerkRecord) float64 { return r.JerkZ }),
+			)
+		}
+	}
+	if cfg.Derivatives >= 3 {
+		cols = append(cols, doubleColumn("snap", func(r *JerkRecord) float64 { return r.Snap }))
+	}
+	if cfg.Orientation {
+		cols = append(cols,
+			doubleColumn("angular_velocity", func(r *JerkRecord) float64 { return r.AngularVelocity }),
+			doubleColumn("heading_change_rate", func(r *JerkRecord) float64 { return r.HeadingChangeRate }),
+		)
+	}
+	if cfg.Kinematics {
+		cols = append(cols,
+			doubleColumn("pos_x", func(r *JerkRecord) float64 { return r.PosX }),
+			doubleColumn("pos_y", func(r *JerkRecord) float64 { return r.PosY }),
+			doubleColumn("pos_z", func(r *JerkRecord) float64 { return r.PosZ }),
+			doubleColumn("vel_x", func(r *JerkRecord) float64 { return r.VelX }),
+			doubleColumn("vel_y", func(r *JerkRecord) float64 { return r.VelY }),
+			doubleColumn("vel_z", func(r *JerkRecord) float64 { return r.VelZ }),
+		)
+	}
+	cols = append(cols,
+		doubleColumn("distance", func(r *JerkRecord) float64 { return r.Distance }),
+		doubleColumn("nearest_opponent_dist", func(r *JerkRecord) float64 { return r.NearestOpponentDist }),
+		doubleColumn("nearest_teammate_dist", func(r *JerkRecord) float64 { return r.NearestTeammateDist }),
+		doubleColumn("dist_to_team_centroid", func(r *JerkRecord) float64 { return r.DistToTeamCentroid }),
+		doubleColumn("dist_to_disc", func(r *JerkRecord) float64 { return r.DistToDisc }),
+		doubleColumn("approach_speed_to_disc", func(r *JerkRecord) float64 { return r.ApproachSpeedToDisc }),
+	)
+	if cfg.TeamSwaps {
+		cols = append(cols, boolColumn("team_changed", func(r *JerkRecord) bool { return r.TeamChanged }))
+	}
+	if cfg.MaxSpeed > 0 {
+		cols = append(cols, boolColumn("suspect", func(r *JerkRecord) bool { return r.Suspect }))
+	}
+	return cols
+}
+
+// formatRealTime returns the record's capture time as an RFC 3339 string in
+// UTC, or "" when it has none
+func formatRealTime(r *JerkRecord) string {
+	if r.RealTime.IsZero() {
+		return ""
+	}
+	return r.RealTime.UTC().Format(time.RFC3339Nano)
+}
+
+// AllColumns returns every column OutputColumns can produce, whatever the
+// configuration, in output order
+func AllColumns() []Column {
+	return OutputColumns(Config{
+		Derivatives:  3,
+		PerAxis:      true,
+		DisplayNames: true,
+		Orientation:  true,
+		Kinematics:   true,
+		TeamSwaps:    true,
+		MaxSpeed:     math.Inf(1),
+		Gravity:      &Vec3{},
+	})
+}
+
+// SelectColumns returns the named columns in the given order. Every record
+// carries all of its fields, so any column of AllColumns can be selected,
+// and an unknown or repeated name is an error.
+func SelectColumns(names []string) ([]Column, error) {
+	known := make(map[string]Column)
+	for _, c := range AllColumns() {
+		known[c.Name] = c
+	}
+	cols := make([]Column, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		c, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q is selected more than once", name)
+		}
+		seen[name] = true
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// ExcludeColumns returns cols without the named columns. Every name must be
+// a column of AllColumns, but need not be in cols.
+func ExcludeColumns(cols []Column, names []string) ([]Column, error) {
+	excluded := make(map[string]bool)
+	for _, name := range names {
+		if _, err := SelectColumns([]string{name}); err != nil {
+			return nil, err
+		}
+		excluded[name] = true
+	}
+	out := make([]Column, 0, len(cols))
+	for _, c := range cols {
+		if !excluded[c.Name] {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
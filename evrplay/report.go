@@ -0,0 +1,71 @@
+package evrplay
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// SessionTiming summarizes the frame timing of one session, for spotting
+// captures with gaps or wrong timing
+type SessionTiming struct {
+	SessionID string
+	Frames    int
+	MinTime   float64
+	MaxTime   float64
+}
+
+// Duration returns the time spanned by the session's frames
+func (s SessionTiming) Duration() float64 {
+	return s.MaxTime - s.MinTime
+}
+
+// FrameRate returns the average number of frames per second, or NaN when
+// the frames span no time
+func (s SessionTiming) FrameRate() float64 {
+	if s.Duration() <= 0 {
+		return math.NaN()
+	}
+	return float64(s.Frames-1) / s.Duration()
+}
+
+// SessionReport accumulates the timing of every session without keeping
+// the frames. It is safe for concurrent use, so processors running in
+// parallel can share one report.
+type SessionReport struct {
+	mu       sync.Mutex
+	sessions map[string]*SessionTiming
+}
+
+// NewSessionReport returns an empty SessionReport
+func NewSessionReport() *SessionReport {
+	return &SessionReport{sessions: make(map[string]*SessionTiming)}
+}
+
+// Add folds a frame into its session's timing
+func (r *SessionReport) Add(frame EchoVRFrame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[frame.SessionID]
+	if !ok {
+		s = &SessionTiming{SessionID: frame.SessionID, MinTime: frame.Time, MaxTime: frame.Time}
+		r.sessions[frame.SessionID] = s
+	}
+	s.Frames++
+	s.MinTime = math.Min(s.MinTime, frame.Time)
+	s.MaxTime = math.Max(s.MaxTime, frame.Time)
+}
+
+// Sessions returns the timing of every session sorted by session ID
+func (r *SessionReport) Sessions() []SessionTiming {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]SessionTiming, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out
+}
@@ -0,0 +1,115 @@
+package evrplay
+
+import (
+	"math"
+	"time"
+)
+
+// gridEpsilon is the tolerance, in grid intervals, for matching a frame time
+// to a grid time
+const gridEpsilon = 1e-9
+
+// resampler interpolates frames onto a uniform time grid, one session at a
+// time
+type resampler struct {
+	hz     float64
+	maxGap float64
+	last   map[string]*EchoVRFrame // previous frame of each session
+}
+
+func newResampler(hz, maxGap float64) *resampler {
+	return &resampler{hz: hz, maxGap: maxGap, last: make(map[string]*EchoVRFrame)}
+}
+
+// next calls emit with an interpolated frame for every grid time after the
+// session's previous frame and up to and including frame's time. Nothing is
+// emitted for the first frame of a session, across a gap longer than maxGap,
+// or when the clock did not advance.
+func (r *resampler) next(frame EchoVRFrame, emit func(EchoVRFrame) error) error {
+	prev, ok := r.last[frame.SessionID]
+	if !ok {
+		prev = &EchoVRFrame{}
+		r.last[frame.SessionID] = prev
+	}
+	// Copied after emitting, since frame's slices may be reused by the caller
+	defer copyFrame(prev, frame)
+
+	span := frame.Time - prev.Time
+	if !ok || span <= 0 || span > r.maxGap {
+		return nil
+	}
+
+	byUser := make(map[string]Player)
+	for _, team := range prev.Teams {
+		for _, player := range team.Players {
+			byUser[player.UserID] = player
+		}
+	}
+
+	// A grid time that falls on a frame belongs to the interval it ends,
+	// even if float error puts it a hair outside
+	first := math.Floor(prev.Time*r.hz+gridEpsilon) + 1
+	last := math.Floor(frame.Time*r.hz + gridEpsilon)
+	for k := first; k <= last; k++ {
+		t := k / r.hz
+		if err := emit(interpolateFrame(prev, frame, byUser, t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interpolateFrame builds the frame at time t between prev and cur. Only
+// players present in both frames are included, keeping cur's teams; prev's
+// players are looked up in byUser.
+func interpolateFrame(prev *EchoVRFrame, cur EchoVRFrame, byUser map[string]Player, t float64) EchoVRFrame {
+	f := (t - prev.Time) / (cur.Time - prev.Time)
+	out := EchoVRFrame{SessionID: cur.SessionID, Time: t, Teams: make([]Team, len(cur.Teams))}
+	if !prev.RealTime.IsZero() && !cur.RealTime.IsZero() {
+		span := cur.RealTime.Sub(prev.RealTime.Time)
+		out.RealTime.Time = prev.RealTime.Add(time.Duration(float64(span) * f))
+	}
+	if prev.Disc != nil && cur.Disc != nil {
+		out.Disc = &Disc{
+			Position: prev.Disc.Position.Lerp(cur.Disc.Position, f),
+			Velocity: prev.Disc.Velocity.Lerp(cur.Disc.Velocity, f),
+		}
+	}
+
+	for ti, team := range cur.Teams {
+		for _, player := range team.Players {
+			before, ok := byUser[player.UserID]
+			if !ok {
+				continue
+			}
+			out.Teams[ti].Players = append(out.Teams[ti].Players, Player{
+				UserID:      player.UserID,
+				DisplayName: player.DisplayName,
+				Role:        player.Role,
+				Position:    before.Position.Lerp(player.Position, f),
+				Velocity:    before.Velocity.Lerp(player.Velocity, f),
+				Forward:     before.Forward.Lerp(player.Forward, f),
+				Up:          before.Up.Lerp(player.Up, f),
+			})
+		}
+	}
+	return out
+}
+
+// copyFrame deep copies src into dst, reusing dst's slices
+func copyFrame(dst *EchoVRFrame, src EchoVRFrame) {
+	teams := dst.Teams[:0]
+	for i, team := range src.Teams {
+		var players []Player
+		if i < cap(dst.Teams) {
+			players = dst.Teams[:cap(dst.Teams)][i].Players[:0]
+		}
+		teams = append(teams, Team{Players: append(players, team.Players...)})
+	}
+
+	*dst = EchoVRFrame{SessionID: src.SessionID, Time: src.Time, RealTime: src.RealTime, Teams: teams}
+	if src.Disc != nil {
+		disc := *src.Disc
+		dst.Disc = &disc
+	}
+}
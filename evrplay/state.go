@@ -0,0 +1,135 @@
+package evrplay
+
+import "math"
+
+// PlayerState tracks the state of a player across frames
+type PlayerState struct {
+	LastPosition Vec3
+	LastVelocity Vec3
+	LastForward  Vec3
+	LastAccel    Vec3
+	LastJerk     Vec3
+	LastTime     float64
+	Distance     float64
+	FrameIndex   int64 // index of the player's next sample
+	HasPrevious  bool
+	HasJerk      bool
+
+	// LastTeam is the player's team index in their previous frame, kept
+	// across history resets, and HasTeam whether they had one
+	LastTeam int32
+	HasTeam  bool
+
+	// lastDt is the time step of the latest sample, or 0 when the sample
+	// did not follow an earlier one in the same history
+	lastDt float64
+
+	// window holds the recent velocity samples that LastVelocity averages
+	window velocityWindow
+}
+
+// reset discards the player's history and primes it with a fresh sample
+func (s *PlayerState) reset(player Player, t float64) {
+	window := s.window
+	window.clear()
+	window.push(player.Velocity)
+	*s = PlayerState{
+		LastPosition: player.Position,
+		LastVelocity: window.mean(),
+		LastForward:  player.Forward,
+		LastTime:     t,
+		HasPrevious:  false,
+		LastTeam:     s.LastTeam,
+		HasTeam:      s.HasTeam,
+		window:       window,
+	}
+}
+
+// duplicateEpsilon is the tolerance for treating two samples as the same
+const duplicateEpsilon = 1e-9
+
+// isDuplicate reports whether a sample at time t repeats the player's
+// previous sample
+func (s *PlayerState) isDuplicate(t float64, player Player) bool {
+	return math.Abs(t-s.LastTime) <= duplicateEpsilon &&
+		player.Position.Equals(s.LastPosition, duplicateEpsilon) &&
+		player.Velocity.Equals(s.window.latest(), duplicateEpsilon)
+}
+
+// nextFrameIndex returns the index for the player's current sample and
+// advances the counter
+func (s *PlayerState) nextFrameIndex() int64 {
+	i := s.FrameIndex
+	s.FrameIndex++
+	return i
+}
+
+// velocityWindow is a fixed-size ring buffer of velocity samples
+type velocityWindow struct {
+	samples []Vec3
+	size    int
+	next    int
+}
+
+func newVelocityWindow(size int) velocityWindow {
+	if size < 1 {
+		size = 1
+	}
+	return velocityWindow{samples: make([]Vec3, 0, size), size: size}
+}
+
+// push adds a sample, evicting the oldest once the window is full
+func (w *velocityWindow) push(v Vec3) {
+	if len(w.samples) < w.size {
+		w.samples = append(w.samples, v)
+		return
+	}
+	w.samples[w.next] = v
+	w.next = (w.next + 1) % w.size
+}
+
+// mean averages the samples seen so far, which may be fewer than the window
+// size early in a player's history
+func (w *velocityWindow) mean() Vec3 {
+	if len(w.samples) == 1 {
+		return w.samples[0]
+	}
+	var sum Vec3
+	for _, v := range w.samples {
+		sum = sum.Add(v)
+	}
+	return sum.Scale(1 / float64(len(w.samples)))
+}
+
+// latest returns the most recently pushed sample, which is the raw
+// velocity behind the smoothed LastVelocity
+func (w *velocityWindow) latest() Vec3 {
+	if len(w.samples) == 0 {
+		return Vec3{}
+	}
+	if len(w.samples) < w.size {
+		return w.samples[len(w.samples)-1]
+	}
+	return w.samples[(w.next+w.size-1)%w.size]
+}
+
+// ordered returns a copy of the samples, oldest first
+func (w *velocityWindow) ordered() []Vec3 {
+	out := make([]Vec3, 0, len(w.samples))
+	if len(w.samples) < w.size {
+		return append(out, w.samples...)
+	}
+	out = append(out, w.samples[w.next:]...)
+	return append(out, w.samples[:w.next]...)
+}
+
+func (w *velocityWindow) clear() {
+	w.samples = w.samples[:0]
+	w.next = 0
+}
+
+// PlayerKey uniquely identifies a player in a session
+type PlayerKey struct {
+	SessionID string
+	UserID    string
+}
@@ -0,0 +1,140 @@
+package evrplay
+
+import (
+	"math"
+	"sort"
+)
+
+// PlayerSummary accumulates jerk statistics for one player without keeping
+// the individual samples, using Welford's online algorithm for the variance
+// and P² estimates for the percentiles
+type PlayerSummary struct {
+	Key   PlayerKey
+	Count int
+	Min   float64
+	Max   float64
+
+	// Moving and Idle are the seconds the player spent at or above and below
+	// SummarySink.IdleSpeed
+	Moving float64
+	Idle   float64
+
+	mean float64
+	m2   float64
+
+	p50, p90, p99 p2Quantile
+}
+
+// Add folds a jerk sample into the summary
+func (s *PlayerSummary) Add(x float64) {
+	s.Count++
+	if s.Count == 1 {
+		s.Min, s.Max = x, x
+		s.p50, s.p90, s.p99 = newP2Quantile(0.5), newP2Quantile(0.9), newP2Quantile(0.99)
+	} else {
+		s.Min = math.Min(s.Min, x)
+		s.Max = math.Max(s.Max, x)
+	}
+
+	delta := x - s.mean
+	s.mean += delta / float64(s.Count)
+	s.m2 += delta * (x - s.mean)
+
+	s.p50.Add(x)
+	s.p90.Add(x)
+	s.p99.Add(x)
+}
+
+// Mean returns the mean of the samples
+func (s *PlayerSummary) Mean() float64 {
+	return s.mean
+}
+
+// P50, P90, and P99 return the estimated 50th, 90th, and 99th percentiles
+// of the samples, or NaN without samples. They are exact for fewer than
+// five samples and approximate beyond that.
+func (s *PlayerSummary) P50() float64 { return s.p50.Value() }
+func (s *PlayerSummary) P90() float64 { return s.p90.Value() }
+func (s *PlayerSummary) P99() float64 { return s.p99.Value() }
+
+// IdleFraction returns the fraction of the player's tracked time spent
+// idle, or NaN when none was tracked
+func (s *PlayerSummary) IdleFraction() float64 {
+	total := s.Moving + s.Idle
+	if total <= 0 {
+		return math.NaN()
+	}
+	return s.Idle / total
+}
+
+// StdDev returns the sample standard deviation, or 0 with fewer than two
+// samples
+func (s *PlayerSummary) StdDev() float64 {
+	if s.Count < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.Count-1))
+}
+
+// SummarySink is a RecordSink that accumulates per-player jerk statistics.
+// Records without a defined jerk only count towards the idle statistics.
+type SummarySink struct {
+	// IdleSpeed, when positive, splits each player's time into time moving
+	// and time idle by comparing the speed at the end of every time step
+	// with it
+	IdleSpeed float64
+
+	players map[PlayerKey]*PlayerSummary
+}
+
+// NewSummarySink returns an empty SummarySink
+func NewSummarySink() *SummarySink {
+	return &SummarySink{players: make(map[PlayerKey]*PlayerSummary)}
+}
+
+func (s *SummarySink) Write(r *JerkRecord) error {
+	idle := s.IdleSpeed > 0
+	if math.IsNaN(r.Jerk) && !idle {
+		return nil
+	}
+
+	key := PlayerKey{SessionID: r.SessionID, UserID: r.UserID}
+	summary, ok := s.players[key]
+	if !ok {
+		summary = &PlayerSummary{Key: key}
+		s.players[key] = summary
+	}
+	if idle && r.DeltaTime > 0 {
+		if r.Speed < s.IdleSpeed {
+			summary.Idle += r.DeltaTime
+		} else {
+			summary.Moving += r.DeltaTime
+		}
+	}
+	if !math.IsNaN(r.Jerk) {
+		summary.Add(r.Jerk)
+	}
+	return nil
+}
+
+func (s *SummarySink) Close() error {
+	return nil
+}
+
+// Summaries returns the per-player summaries sorted by descending max jerk
+func (s *SummarySink) Summaries() []*PlayerSummary {
+	out := make([]*PlayerSummary, 0, len(s.players))
+	for _, summary := range s.players {
+		out = append(out, summary)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Max != out[j].Max {
+			return out[i].Max > out[j].Max
+		}
+		if out[i].Key.SessionID != out[j].Key.SessionID {
+			return out[i].Key.SessionID < out[j].Key.SessionID
+		}
+		return out[i].Key.UserID < out[j].Key.UserID
+	})
+	return out
+}
@@ -0,0 +1,166 @@
+package evrplay
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FieldError reports a required field of an input frame that is missing or
+// empty
+type FieldError struct {
+	Field string // path to the field, such as teams[0].players[1].position
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("required field %s is missing or empty", e.Field)
+}
+
+// strictSimpleFrame records which required fields of a simple frame are
+// present; absent and null fields decode as nil
+type strictSimpleFrame struct {
+	SessionID *string  `json:"sessionid"`
+	GameClock *float64 `json:"game_clock"`
+	Teams     []struct {
+		Players *[]struct {
+			UserID   *string          `json:"userid"`
+			Position *json.RawMessage `json:"position"`
+			Velocity *json.RawMessage `json:"velocity"`
+		} `json:"players"`
+	} `json:"teams"`
+}
+
+func (f *strictSimpleFrame) validate() error {
+	if f.SessionID == nil || *f.SessionID == "" {
+		return &FieldError{"sessionid"}
+	}
+	if f.GameClock == nil {
+		return &FieldError{"game_clock"}
+	}
+	if len(f.Teams) == 0 {
+		return &FieldError{"teams"}
+	}
+	for ti, team := range f.Teams {
+		if team.Players == nil {
+			return &FieldError{fmt.Sprintf("teams[%d].players", ti)}
+		}
+		for pi, p := range *team.Players {
+			at := fmt.Sprintf("teams[%d].players[%d]", ti, pi)
+			switch {
+			case p.UserID == nil || *p.UserID == "":
+				return &FieldError{at + ".userid"}
+			}
+			if err := validateVector(p.Position, at+".position"); err != nil {
+				return err
+			}
+			if err := validateVector(p.Velocity, at+".velocity"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// strictOfficialFrame is strictSimpleFrame for the official layout. Teams
+// without players are valid there, and spectators are not checked.
+type strictOfficialFrame struct {
+	SessionID *string  `json:"sessionid"`
+	GameClock *float64 `json:"game_clock"`
+	Teams     []struct {
+		Players []struct {
+			UserID *json.Number `json:"userid"`
+			Head   *struct {
+				Position *json.RawMessage `json:"position"`
+			} `json:"head"`
+			Velocity *json.RawMessage `json:"velocity"`
+		} `json:"players"`
+	} `json:"teams"`
+}
+
+func (f *strictOfficialFrame) validate() error {
+	if f.SessionID == nil || *f.SessionID == "" {
+		return &FieldError{"sessionid"}
+	}
+	if f.GameClock == nil {
+		return &FieldError{"game_clock"}
+	}
+	if len(f.Teams) == 0 {
+		return &FieldError{"teams"}
+	}
+	teams := f.Teams
+	if len(teams) > officialTeams {
+		teams = teams[:officialTeams]
+	}
+	for ti, team := range teams {
+		for pi, p := range team.Players {
+			at := fmt.Sprintf("teams[%d].players[%d]", ti, pi)
+			switch {
+			case p.UserID == nil || *p.UserID == "":
+				return &FieldError{at + ".userid"}
+			case p.Head == nil:
+				return &FieldError{at + ".head.position"}
+			}
+			if err := validateVector(p.Head.Position, at+".head.position"); err != nil {
+				return err
+			}
+			if err := validateVector(p.Velocity, at+".velocity"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateVector checks that the vector at the given field path has every
+// component, either as an object with x, y, and z or as a three-element
+// array, so a partial vector is not silently completed with zeros
+func validateVector(raw *json.RawMessage, at string) error {
+	if raw == nil {
+		return &FieldError{at}
+	}
+	var components []*float64
+	if json.Unmarshal(*raw, &components) == nil {
+		if len(components) != 3 {
+			return &FieldError{at}
+		}
+		for i, c := range components {
+			if c == nil {
+				return &FieldError{fmt.Sprintf("%s[%d]", at, i)}
+			}
+		}
+		return nil
+	}
+
+	var v struct {
+		X *float64 `json:"x"`
+		Y *float64 `json:"y"`
+		Z *float64 `json:"z"`
+	}
+	if err := json.Unmarshal(*raw, &v); err != nil {
+		return err
+	}
+	switch {
+	case v.X == nil:
+		return &FieldError{at + ".x"}
+	case v.Y == nil:
+		return &FieldError{at + ".y"}
+	case v.Z == nil:
+		return &FieldError{at + ".z"}
+	}
+	return nil
+}
+
+// validateFrame checks that a line which decoded successfully has every
+// field the schema requires, returning a *FieldError naming the first one
+// that is missing or empty
+func validateFrame(schema Schema, line []byte) error {
+	var frame interface{ validate() error }
+	if schema == SchemaOfficial {
+		frame = &strictOfficialFrame{}
+	} else {
+		frame = &strictSimpleFrame{}
+	}
+	if err := json.Unmarshal(line, frame); err != nil {
+		return err
+	}
+	return frame.validate()
+}
@@ -0,0 +1,72 @@
+package evrplay
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateFramePartialVectors(t *testing.T) {
+	player := func(position, velocity string) string {
+		return `{"sessionid":"s","game_clock":1,"teams":[{"players":[{"userid":"a","position":` +
+			position + `,"velocity":` + velocity + `}]}]}`
+	}
+	official := func(position string) string {
+		return `{"sessionid":"s","game_clock":1,"teams":[{"players":[{"userid":1,"head":{"position":` +
+			position + `},"velocity":[0,0,0]}]}]}`
+	}
+	tests := []struct {
+		schema Schema
+		line   string
+		field  string // "" for a valid line
+	}{
+		{SchemaSimple, player(`{"x":1,"y":2,"z":3}`, `[0,0,0]`), ""},
+		{SchemaSimple, player(`[1,2,3]`, `{"x":0,"y":0,"z":0}`), ""},
+		{SchemaSimple, player(`{}`, `[0,0,0]`), "teams[0].players[0].position.x"},
+		{SchemaSimple, player(`{"x":1}`, `[0,0,0]`), "teams[0].players[0].position.y"},
+		{SchemaSimple, player(`{"x":1,"y":2}`, `[0,0,0]`), "teams[0].players[0].position.z"},
+		{SchemaSimple, player(`{"x":1,"y":null,"z":3}`, `[0,0,0]`), "teams[0].players[0].position.y"},
+		{SchemaSimple, player(`[1,2,3]`, `{}`), "teams[0].players[0].velocity.x"},
+		{SchemaSimple, player(`[1,null,3]`, `[0,0,0]`), "teams[0].players[0].position[1]"},
+		{SchemaSimple, player(`null`, `[0,0,0]`), "teams[0].players[0].position"},
+		{SchemaOfficial, official(`[1,2,3]`), ""},
+		{SchemaOfficial, official(`{}`), "teams[0].players[0].head.position.x"},
+		{SchemaOfficial, official(`{"x":1}`), "teams[0].players[0].head.position.y"},
+	}
+	for _, tt := range tests {
+		err := validateFrame(tt.schema, []byte(tt.line))
+		var ferr *FieldError
+		switch {
+		case tt.field == "" && err != nil:
+			t.Errorf("validateFrame(%s) = %v, want nil", tt.line, err)
+		case tt.field != "" && (!errors.As(err, &ferr) || ferr.Field != tt.field):
+			t.Errorf("validateFrame(%s) = %v, want missing %s", tt.line, err, tt.field)
+		}
+	}
+}
+
+func TestStrictRejectsEmptyPosition(t *testing.T) {
+	input := `{"sessionid":"s","game_clock":0,"teams":[{"players":[{"userid":"a","position":[0,0,0],"velocity":[0,0,0]}]}]}
+{"sessionid":"s","game_clock":0.1,"teams":[{"players":[{"userid":"a","position":{},"velocity":[0,0,0]}]}]}
+`
+	cfg := DefaultConfig()
+	cfg.Strict = true
+	var fields []string
+	cfg.OnParseError = func(line int, err error) {
+		var ferr *FieldError
+		if errors.As(err, &ferr) {
+			fields = append(fields, ferr.Field)
+		}
+	}
+	sink := &collectSink{}
+	p := NewProcessor(cfg, sink)
+	if err := p.ProcessStream(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.Stats(); stats.ParseErrors != 1 || len(sink.records) != 1 {
+		t.Errorf("got %d parse errors and %d records, want 1 and 1", stats.ParseErrors, len(sink.records))
+	}
+	if len(fields) != 1 || fields[0] != "teams[0].players[0].position.x" {
+		t.Errorf("rejected fields %v, want teams[0].players[0].position.x", fields)
+	}
+}
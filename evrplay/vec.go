@@ -0,0 +1,131 @@
+package evrplay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Vec3 represents a 3D vector
+type Vec3 struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// vec3Object is Vec3 without its UnmarshalJSON method, for decoding the
+// object form
+type vec3Object Vec3
+
+// UnmarshalJSON accepts both the {"x": 1, "y": 2, "z": 3} object form and the
+// [1, 2, 3] array form that some feeds use
+func (v *Vec3) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) == 0 || data[0] != '[' {
+		return json.Unmarshal(data, (*vec3Object)(v))
+	}
+
+	var components []float64
+	if err := json.Unmarshal(data, &components); err != nil {
+		return err
+	}
+	if len(components) != 3 {
+		return fmt.Errorf("vector array has %d elements, want 3", len(components))
+	}
+	*v = Vec3{X: components[0], Y: components[1], Z: components[2]}
+	return nil
+}
+
+// Magnitude returns the magnitude of a vector
+func (v Vec3) Magnitude() float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+}
+
+// Add returns the sum of two vectors
+func (v Vec3) Add(other Vec3) Vec3 {
+	return Vec3{
+		X: v.X + other.X,
+		Y: v.Y + other.Y,
+		Z: v.Z + other.Z,
+	}
+}
+
+// Sub returns the difference between two vectors
+func (v Vec3) Sub(other Vec3) Vec3 {
+	return Vec3{
+		X: v.X - other.X,
+		Y: v.Y - other.Y,
+		Z: v.Z - other.Z,
+	}
+}
+
+// Scale returns the vector multiplied by a scalar
+func (v Vec3) Scale(s float64) Vec3 {
+	return Vec3{
+		X: v.X * s,
+		Y: v.Y * s,
+		Z: v.Z * s,
+	}
+}
+
+// Dot returns the dot product of two vectors
+func (v Vec3) Dot(other Vec3) float64 {
+	return v.X*other.X + v.Y*other.Y + v.Z*other.Z
+}
+
+// Cross returns the right-handed cross product of two vectors
+func (v Vec3) Cross(other Vec3) Vec3 {
+	return Vec3{
+		X: v.Y*other.Z - v.Z*other.Y,
+		Y: v.Z*other.X - v.X*other.Z,
+		Z: v.X*other.Y - v.Y*other.X,
+	}
+}
+
+// Distance returns the Euclidean distance between two points
+func (v Vec3) Distance(other Vec3) float64 {
+	return v.Sub(other).Magnitude()
+}
+
+// Equals reports whether every component of v is within eps of other's
+func (v Vec3) Equals(other Vec3, eps float64) bool {
+	return math.Abs(v.X-other.X) <= eps &&
+		math.Abs(v.Y-other.Y) <= eps &&
+		math.Abs(v.Z-other.Z) <= eps
+}
+
+// Lerp linearly interpolates between v at t=0 and other at t=1. Both ends
+// are reproduced exactly.
+func (v Vec3) Lerp(other Vec3, t float64) Vec3 {
+	return Vec3{
+		X: v.X*(1-t) + other.X*t,
+		Y: v.Y*(1-t) + other.Y*t,
+		Z: v.Z*(1-t) + other.Z*t,
+	}
+}
+
+// normalizeEpsilon is the smallest magnitude Normalize treats as having a
+// direction
+const normalizeEpsilon = 1e-9
+
+// Normalize returns the unit vector in the direction of v. It returns false,
+// and the zero vector, when v is too short to have a meaningful direction.
+func (v Vec3) Normalize() (Vec3, bool) {
+	m := v.Magnitude()
+	if m < normalizeEpsilon {
+		return Vec3{}, false
+	}
+	return v.Scale(1 / m), true
+}
+
+// angleBetween returns the angle in radians between two vectors, or NaN if
+// either is zero. The cosine is clamped so float error can't push it outside
+// acos's domain.
+func angleBetween(a, b Vec3) float64 {
+	m := a.Magnitude() * b.Magnitude()
+	if m == 0 {
+		return math.NaN()
+	}
+	return math.Acos(math.Max(-1, math.Min(1, a.Dot(b)/m)))
+}
@@ -0,0 +1,212 @@
+package evrplay
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestVec3Add(t *testing.T) {
+	tests := []struct {
+		a, b, want Vec3
+	}{
+		{Vec3{}, Vec3{}, Vec3{}},
+		{Vec3{1, 2, 3}, Vec3{}, Vec3{1, 2, 3}},
+		{Vec3{1, 2, 3}, Vec3{4, -5, 6}, Vec3{5, -3, 9}},
+		{Vec3{1, 2, 3}, Vec3{-1, -2, -3}, Vec3{}},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Add(tt.b); got != tt.want {
+			t.Errorf("%v.Add(%v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := tt.b.Add(tt.a); got != tt.want {
+			t.Errorf("%v.Add(%v) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestVec3Scale(t *testing.T) {
+	tests := []struct {
+		v    Vec3
+		s    float64
+		want Vec3
+	}{
+		{Vec3{1, -2, 3}, 0, Vec3{}},
+		{Vec3{1, -2, 3}, 1, Vec3{1, -2, 3}},
+		{Vec3{1, -2, 3}, -1, Vec3{-1, 2, -3}},
+		{Vec3{1, -2, 3}, 2.5, Vec3{2.5, -5, 7.5}},
+		{Vec3{}, 10, Vec3{}},
+	}
+	for _, tt := range tests {
+		if got := tt.v.Scale(tt.s); got != tt.want {
+			t.Errorf("%v.Scale(%v) = %v, want %v", tt.v, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestVec3DotCross(t *testing.T) {
+	x, y, z := Vec3{X: 1}, Vec3{Y: 1}, Vec3{Z: 1}
+	tests := []struct {
+		a, b  Vec3
+		dot   float64
+		cross Vec3
+	}{
+		{x, y, 0, z},
+		{y, z, 0, x},
+		{z, x, 0, y},
+		{y, x, 0, Vec3{Z: -1}},
+		{x, x, 1, Vec3{}},
+		{Vec3{1, 2, 3}, Vec3{4, 5, 6}, 32, Vec3{-3, 6, -3}},
+		{Vec3{1, 2, 3}, Vec3{-2, -4, -6}, -28, Vec3{}},
+		{Vec3{}, Vec3{4, 5, 6}, 0, Vec3{}},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Dot(tt.b); got != tt.dot {
+			t.Errorf("%v.Dot(%v) = %v, want %v", tt.a, tt.b, got, tt.dot)
+		}
+		cross := tt.a.Cross(tt.b)
+		if cross != tt.cross {
+			t.Errorf("%v.Cross(%v) = %v, want %v", tt.a, tt.b, cross, tt.cross)
+		}
+		// The cross product is perpendicular to both operands
+		if cross.Dot(tt.a) != 0 || cross.Dot(tt.b) != 0 {
+			t.Errorf("%v.Cross(%v) = %v is not perpendicular to both", tt.a, tt.b, cross)
+		}
+	}
+}
+
+func TestVec3Distance(t *testing.T) {
+	tests := []struct {
+		a, b Vec3
+		want float64
+	}{
+		{Vec3{}, Vec3{}, 0},
+		{Vec3{1, 2, 3}, Vec3{1, 2, 3}, 0},
+		{Vec3{}, Vec3{3, 4, 0}, 5},
+		{Vec3{1, 1, 1}, Vec3{3, 4, 7}, 7},
+		{Vec3{-1, -2, -2}, Vec3{}, 3},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Distance(tt.b); got != tt.want {
+			t.Errorf("%v.Distance(%v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := tt.b.Distance(tt.a); got != tt.want {
+			t.Errorf("%v.Distance(%v) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestVec3Normalize(t *testing.T) {
+	tests := []struct {
+		v    Vec3
+		want Vec3
+		ok   bool
+	}{
+		{Vec3{}, Vec3{}, false},
+		{Vec3{3, 0, 4}, Vec3{0.6, 0, 0.8}, true},
+		{Vec3{0, -2, 0}, Vec3{0, -1, 0}, true},
+		{Vec3{X: normalizeEpsilon}, Vec3{X: 1}, true},
+		{Vec3{X: normalizeEpsilon / 2}, Vec3{}, false},
+		{Vec3{Y: -math.Nextafter(normalizeEpsilon, 0)}, Vec3{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.v.Normalize()
+		if ok != tt.ok || !got.Equals(tt.want, 1e-12) {
+			t.Errorf("%v.Normalize() = %v, %v; want %v, %v", tt.v, got, ok, tt.want, tt.ok)
+		}
+		if ok && math.Abs(got.Magnitude()-1) > 1e-12 {
+			t.Errorf("%v.Normalize() = %v has magnitude %v", tt.v, got, got.Magnitude())
+		}
+	}
+}
+
+func TestVec3Lerp(t *testing.T) {
+	a, b := Vec3{0.1, -3, 7}, Vec3{0.7, 5, -2.3}
+	tests := []struct {
+		t    float64
+		want Vec3
+	}{
+		{0, a},
+		{1, b},
+		{0.5, Vec3{0.4, 1, 2.35}},
+		{0.25, Vec3{0.25, -1, 4.675}},
+		{2, Vec3{1.3, 13, -11.6}},
+	}
+	for _, tt := range tests {
+		got := a.Lerp(b, tt.t)
+		// The ends must come back exactly, not just within rounding
+		eps := 1e-12
+		if tt.t == 0 || tt.t == 1 {
+			eps = 0
+		}
+		if !got.Equals(tt.want, eps) {
+			t.Errorf("%v.Lerp(%v, %v) = %v, want %v", a, b, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestVec3Equals(t *testing.T) {
+	tests := []struct {
+		a, b Vec3
+		eps  float64
+		want bool
+	}{
+		{Vec3{1, 2, 3}, Vec3{1, 2, 3}, 0, true},
+		{Vec3{1, 2, 3}, Vec3{1, 2, 3.5}, 0, false},
+		{Vec3{1, 2, 3}, Vec3{1, 2, 3.5}, 0.5, true},
+		{Vec3{1, 2, 3}, Vec3{1, 2, 3.5}, math.Nextafter(0.5, 0), false},
+		{Vec3{}, Vec3{X: 1e-9}, 1e-9, true},
+		{Vec3{}, Vec3{Y: -1e-9}, 1e-9, true},
+		{Vec3{}, Vec3{Z: math.Nextafter(1e-9, 1)}, 1e-9, false},
+		{Vec3{}, Vec3{1e-10, -1e-10, 1e-10}, 1e-9, true},
+		{Vec3{X: math.NaN()}, Vec3{X: math.NaN()}, 1, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Equals(tt.b, tt.eps); got != tt.want {
+			t.Errorf("%v.Equals(%v, %v) = %v, want %v", tt.a, tt.b, tt.eps, got, tt.want)
+		}
+		if got := tt.b.Equals(tt.a, tt.eps); got != tt.want {
+			t.Errorf("%v.Equals(%v, %v) = %v, want %v", tt.b, tt.a, tt.eps, got, tt.want)
+		}
+	}
+}
+
+func TestVec3UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Vec3
+		wantErr bool
+	}{
+		{`{"x": 1, "y": -2.5, "z": 3}`, Vec3{1, -2.5, 3}, false},
+		{`{"y": 2}`, Vec3{Y: 2}, false},
+		{`[1, -2.5, 3]`, Vec3{1, -2.5, 3}, false},
+		{" \n[0,0,1e-3]", Vec3{Z: 1e-3}, false},
+		{`[1, 2]`, Vec3{}, true},
+		{`[1, 2, 3, 4]`, Vec3{}, true},
+		{`[]`, Vec3{}, true},
+		{`["1", 2, 3]`, Vec3{}, true},
+		{`[1, 2, 3`, Vec3{}, true},
+		{`{"x": "1"}`, Vec3{}, true},
+		{`1`, Vec3{}, true},
+	}
+	for _, tt := range tests {
+		var got Vec3
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("Unmarshal(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	// Both forms can appear in the same frame
+	var player Player
+	if err := json.Unmarshal([]byte(`{"position": [1, 2, 3], "velocity": {"x": 4, "y": 5, "z": 6}}`), &player); err != nil {
+		t.Fatal(err)
+	}
+	if player.Position != (Vec3{1, 2, 3}) || player.Velocity != (Vec3{4, 5, 6}) {
+		t.Errorf("player position %v and velocity %v, want [1 2 3] and [4 5 6]", player.Position, player.Velocity)
+	}
+}
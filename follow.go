@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/thesprockee/evr-playspace/evrplay"
+)
+
+// followPollInterval is how long --follow waits at EOF before checking the
+// file for new data
+const followPollInterval = 250 * time.Millisecond
+
+// followFile processes a file that is still being written, like tail -f. It
+// only returns on a read or processing error, or once ctx is done. idle is
+// called whenever the reader has caught up with the writer.
+func followFile(ctx context.Context, p *evrplay.Processor, path string, idle func() error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r := &followReader{ctx: ctx, path: path, f: f, in: newContextReader(ctx, f), idle: idle}
+	defer func() { r.f.Close() }()
+
+	return p.ProcessStreamContext(ctx, r)
+}
+
+// followReader waits for more data at EOF instead of returning io.EOF, and
+// reopens its path when the file is truncated or replaced by log rotation
+type followReader struct {
+	ctx    context.Context
+	path   string
+	f      *os.File
+	in     *contextReader // reads f, so a FIFO stops blocking once ctx is done
+	offset int64
+	idle   func() error
+}
+
+func (r *followReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.in.Read(b)
+		r.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if r.idle != nil {
+			if err := r.idle(); err != nil {
+				return 0, err
+			}
+		}
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-time.After(followPollInterval):
+		}
+		if err := r.reopenIfReplaced(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// reopenIfReplaced starts over from the beginning of the path when it no
+// longer names the open file or the file shrank below what was already read.
+// The old file has been read to EOF by the time this is called.
+func (r *followReader) reopenIfReplaced() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		// The path may be missing for a moment while the file is rotated
+		return nil
+	}
+	current, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	if os.SameFile(info, current) && info.Size() >= r.offset {
+		return nil
+	}
+
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil
+	}
+	r.f.Close()
+	r.f, r.offset = f, 0
+	r.in.r = f
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/thesprockee/evr-playspace/evrplay"
+)
+
+// inputReader processes a named input through p, like processFile
+type inputReader func(ctx context.Context, p *evrplay.Processor, path string) error
+
+// processFile opens a named input file and processes it as a stream until
+// EOF or until ctx is done
+func processFile(ctx context.Context, p *evrplay.Processor, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return processInput(ctx, p, newContextReader(ctx, f), path)
+}
+
+// processStdin processes stdin as a stream until EOF or until ctx is done
+func processStdin(ctx context.Context, p *evrplay.Processor) error {
+	return processInput(ctx, p, newContextReader(ctx, os.Stdin), "")
+}
+
+// contextReader makes reads from r return ctx.Err() once ctx is done, even
+// when r is a pipe or terminal blocked waiting for data. Closing the file
+// does not unblock such a read, so it runs on its own goroutine, which is
+// abandoned along with its buffer on cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+	buf []byte
+}
+
+func newContextReader(ctx context.Context, r io.Reader) *contextReader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if cap(c.buf) < len(b) {
+		c.buf = make([]byte, len(b))
+	}
+	buf, r := c.buf[:len(b)], c.r
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := r.Read(buf)
+		done <- readResult{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		return copy(b, buf[:res.n]), res.err
+	case <-c.ctx.Done():
+		// The pending read still owns the buffer
+		c.buf = nil
+		return 0, c.ctx.Err()
+	}
+}
+
+// readManifest returns the input paths listed in a manifest file, or stdin
+// when path is -, one per line. Blank lines and lines starting with # are
+// skipped.
+func readManifest(path string) ([]string, error) {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
+
+// processConnection accepts a single connection on ln and processes the
+// frames it sends like stdin, until the client disconnects or ctx is done.
+// idle is called before every read from the connection, while no frames are
+// pending.
+func processConnection(ctx context.Context, p *evrplay.Processor, ln net.Listener, idle func() error) error {
+	// Closing the listener and then the connection unblocks a pending
+	// Accept or Read once ctx is done
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	conn, err := ln.Accept()
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	err = processInput(ctx, p, idleReader{conn, idle}, "")
+	if err != nil && ctx.Err() != nil {
+		// The read failed because the connection was closed
+		return ctx.Err()
+	}
+	return err
+}
+
+// idleReader calls idle before each read from r
+type idleReader struct {
+	r    io.Reader
+	idle func() error
+}
+
+func (r idleReader) Read(b []byte) (int, error) {
+	if err := r.idle(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// Magic bytes that start every gzip and zstd stream
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressionByName returns the compression implied by a file name's
+// extension, or "" for an uncompressed file
+func compressionByName(name string) string {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".gz":
+		return "gzip"
+	case ".zst", ".zstd":
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// compressionByMagic returns the compression whose magic bytes start br,
+// or "" when they match none
+func compressionByMagic(br *bufio.Reader) string {
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(magic, zstdMagic):
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// processInput decompresses r when it holds gzip or zstd data and processes
+// it as a stream until EOF or until ctx is done. Named inputs are detected by
+// their extension and unnamed ones such as stdin by their magic bytes.
+func processInput(ctx context.Context, p *evrplay.Processor, r io.Reader, name string) error {
+	br := bufio.NewReader(r)
+
+	compression := compressionByName(name)
+	if name == "" {
+		compression = compressionByMagic(br)
+	}
+
+	dr, closeFn, err := decompress(br, compression)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	return p.ProcessStreamContext(ctx, dr)
+}
+
+// decompress wraps r in a reader for the given compression, or returns it
+// as is when compression is "". The returned function releases the
+// decompressor.
+func decompress(r io.Reader, compression string) (io.Reader, func(), error) {
+	switch compression {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		return decompressErrorReader{zr, compression}, func() { zr.Close() }, nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid zstd stream: %w", err)
+		}
+		return decompressErrorReader{zr, compression}, zr.Close, nil
+	default:
+		return r, func() {}, nil
+	}
+}
+
+// processArchive processes every JSON member of a tar archive, which may
+// itself be gzip- or zstd-compressed, in archive order until ctx is done.
+// Members may be compressed individually too; anything else is skipped.
+func processArchive(ctx context.Context, p *evrplay.Processor, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(newContextReader(ctx, f))
+	r, closeFn, err := decompress(br, compressionByMagic(br))
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	tr := tar.NewReader(r)
+	for !p.Done() {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		member := hdr.Name
+		if compressionByName(member) != "" {
+			member = strings.TrimSuffix(member, path.Ext(member))
+		}
+		if ext := strings.ToLower(path.Ext(member)); ext != ".json" && ext != ".jsonl" {
+			slog.Info("skipping archive member that is not JSON", "archive", name, "member", hdr.Name)
+			continue
+		}
+		if err := processInput(ctx, p, tr, hdr.Name); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+	return nil
+}
+
+// decompressErrorReader labels decompression failures so a truncated or
+// corrupt archive isn't mistaken for a plain read error
+type decompressErrorReader struct {
+	r      io.Reader
+	format string
+}
+
+func (d decompressErrorReader) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("corrupt or truncated %s stream: %w", d.format, err)
+	}
+	return n, err
+}
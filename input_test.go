@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestContextReaderCancelsBlockedRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newContextReader(ctx, pr)
+
+	go func() { pw.Write([]byte("frame\n")) }()
+	b := make([]byte, 16)
+	n, err := r.Read(b)
+	if err != nil || string(b[:n]) != "frame\n" {
+		t.Fatalf("Read = %q, %v; want %q", b[:n], err, "frame\n")
+	}
+
+	// Nothing more is written, so the next read blocks until cancel
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.Read(b)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Read after cancel = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read still blocked after cancel")
+	}
+}
@@ -2,175 +2,879 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"math"
+	"net"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/writer"
+	"github.com/thesprockee/evr-playspace/evrplay"
 )
 
-// Vec3 represents a 3D vector
-type Vec3 struct {
-	X float64 `json:"x"`
-	Y float64 `json:"y"`
-	Z float64 `json:"z"`
+// stringSet is a flag that collects the distinct values of a repeatable
+// string flag
+type stringSet map[string]bool
+
+func (s stringSet) String() string {
+	values := make([]string, 0, len(s))
+	for v := range s {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
 }
 
-// Magnitude returns the magnitude of a vector
-func (v Vec3) Magnitude() float64 {
-	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+func (s stringSet) Set(v string) error {
+	s[v] = true
+	return nil
 }
 
-// Sub returns the difference between two vectors
-func (v Vec3) Sub(other Vec3) Vec3 {
-	return Vec3{
-		X: v.X - other.X,
-		Y: v.Y - other.Y,
-		Z: v.Z - other.Z,
+// splitList splits a comma-separated flag value, trimming space around each
+// entry and dropping empty ones
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
 	}
+	return out
 }
 
-// Player represents a player in EchoVR
-type Player struct {
-	UserID   string `json:"userid"`
-	Position Vec3   `json:"position"`
-	Velocity Vec3   `json:"velocity"`
+// teeOutput is an additional output written alongside the main one
+type teeOutput struct {
+	format string
+	path   string
 }
 
-// EchoVRFrame represents a frame of data from EchoVR
-type EchoVRFrame struct {
-	SessionID string   `json:"sessionid"`
-	Time      float64  `json:"game_clock"`
-	Teams     []Team   `json:"teams"`
-}
+// teeFlag collects the outputs of a repeatable --tee format=path flag
+type teeFlag []teeOutput
 
-// Team represents a team with players
-type Team struct {
-	Players []Player `json:"players"`
+func (t *teeFlag) String() string {
+	specs := make([]string, len(*t))
+	for i, out := range *t {
+		specs[i] = out.format + "=" + out.path
+	}
+	return strings.Join(specs, ",")
 }
 
-// PlayerState tracks the state of a player across frames
-type PlayerState struct {
-	LastPosition Vec3
-	LastVelocity Vec3
-	LastAccel    Vec3
-	HasPrevious  bool
+func (t *teeFlag) Set(v string) error {
+	format, path, ok := strings.Cut(v, "=")
+	if !ok || format == "" || path == "" {
+		return fmt.Errorf("want format=path, such as csv=preview.csv")
+	}
+	*t = append(*t, teeOutput{format: format, path: path})
+	return nil
 }
 
-// PlayerKey uniquely identifies a player in a session
-type PlayerKey struct {
-	SessionID string
-	UserID    string
+// vecFlag is a vector flag written as x,y,z
+type vecFlag evrplay.Vec3
+
+func (v *vecFlag) String() string {
+	return fmt.Sprintf("%g,%g,%g", v.X, v.Y, v.Z)
 }
 
-// JerkRecord represents a row in the output parquet file
-type JerkRecord struct {
-	SessionID string  `parquet:"name=sessionid, type=BYTE_ARRAY, convertedtype=UTF8"`
-	UserID    string  `parquet:"name=userid, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Time      float64 `parquet:"name=time, type=DOUBLE"`
-	Jerk      float64 `parquet:"name=jerk, type=DOUBLE"`
+func (v *vecFlag) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return fmt.Errorf("want x,y,z, such as 0,-9.81,0")
+	}
+	var xyz [3]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("invalid component %q", part)
+		}
+		xyz[i] = f
+	}
+	*v = vecFlag{X: xyz[0], Y: xyz[1], Z: xyz[2]}
+	return nil
 }
 
 func main() {
-	scanner := bufio.NewScanner(os.Stdin)
-	states := make(map[PlayerKey]*PlayerState)
-	var records []JerkRecord
-
-	// Read JSON lines from stdin
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-
-		var frame EchoVRFrame
-		if err := json.Unmarshal(line, &frame); err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
-			continue
-		}
-
-		// Process each player in each team
-		for _, team := range frame.Teams {
-			for _, player := range team.Players {
-				key := PlayerKey{SessionID: frame.SessionID, UserID: player.UserID}
-				state, exists := states[key]
-
-				if !exists {
-					// Initialize state for new player
-					states[key] = &PlayerState{
-						LastPosition: player.Position,
-						LastVelocity: player.Velocity,
-						HasPrevious:  false,
-					}
-					continue
-				}
+	cfg := evrplay.DefaultConfig()
+	sessions, users := stringSet{}, stringSet{}
+	flag.BoolVar(&cfg.Strict, "strict", false, "reject input lines with a missing or empty required field, such as teams or a player's position, and report the field")
+	schema := flag.String("schema", "simple", "input JSON layout: simple, or official for EchoVR /session API responses")
+	flag.BoolVar(&cfg.AssumeUniformDt, "assume-uniform-dt", false, "treat consecutive frames as one time unit apart instead of using game_clock deltas")
+	flag.BoolVar(&cfg.CollapseDuplicates, "collapse-duplicates", true, "skip player samples that repeat the previous sample's time, position, and velocity")
+	flag.BoolVar(&cfg.DropOutOfOrder, "drop-out-of-order", false, "drop player samples whose game_clock does not advance past the previous sample")
+	flag.IntVar(&cfg.Derivatives, "derivatives", 2, "highest derivative column to output: 1=accel, 2=jerk, 3=snap")
+	flag.BoolVar(&cfg.PerAxis, "per-axis", false, "also output the signed jerk_x, jerk_y, and jerk_z components")
+	flag.BoolVar(&cfg.DisplayNames, "display-name", false, "output the players' display names, from player_name (or name with --schema=official)")
+	flag.BoolVar(&cfg.Orientation, "include-orientation", false, "output angular_velocity from the players' forward vectors and heading_change_rate from their velocity")
+	flag.BoolVar(&cfg.Kinematics, "include-kinematics", false, "output the raw pos_x/y/z and vel_x/y/z columns from the players' position and velocity")
+	flag.BoolVar(&cfg.TeamSwaps, "team-swap-detection", false, "output a team_changed column that marks the first record after a player switches teams mid-session")
+	wide := flag.Bool("wide", false, "output every feature column: same as --derivatives 3 --per-axis --display-name --include-orientation --include-kinematics --team-swap-detection, each of which can still be set explicitly")
+	flag.IntVar(&cfg.MaxPlayers, "max-players", 16, "skip frames with more players than this as malformed (0 disables)")
+	flag.IntVar(&cfg.SeedFrames, "seed-frames", 0, "only warm up player state with the first N input frames, suppressing their records, for stitching overlapping chunks")
+	flag.IntVar(&cfg.EveryNth, "every-nth", 1, "process only every Nth frame of each session, for quick looks at long captures")
+	flag.IntVar(&cfg.SmoothWindow, "smooth-window", 1, "average the last N velocity samples per player before computing accel and jerk")
+	flag.Float64Var(&cfg.RejoinGap, "rejoin-gap", 0, "restart a player's derivatives after they are missing for more than this many seconds (0 disables)")
+	flag.Float64Var(&cfg.ResampleHz, "resample-hz", 0, "interpolate frames onto a uniform grid of this many samples per second (0 disables)")
+	flag.Float64Var(&cfg.ResampleMaxGap, "resample-max-gap", 0.25, "longest gap in seconds between input frames to interpolate across when resampling")
+	flag.Float64Var(&cfg.UnitsScale, "units-scale", 1, "multiply input positions and velocities by this factor to convert them to meters")
+	subtractGravity := flag.Bool("subtract-gravity", false, "also output accel_compensated, the acceleration magnitude with --gravity removed")
+	gravity := vecFlag{Y: -9.81}
+	flag.Var(&gravity, "gravity", "gravity vector in m/s^2 as x,y,z, removed from the acceleration by --subtract-gravity")
+	flag.Float64Var(&cfg.MaxSpeed, "max-speed", 0, "flag records whose implied speed between frames exceeds this many m/s in a suspect column (0 disables)")
+	flag.IntVar(&cfg.Limit, "limit", 0, "stop after writing this many output records (0 writes everything)")
+	diff := flag.String("diff", "forward", "finite differences for accel, jerk, and snap: forward, or central for better accuracy at one sample of latency")
+	flag.Float64Var(&cfg.MinDt, "min-dt", 0, "shortest time step in seconds to compute derivatives over, against near-duplicate timestamps (0 disables)")
+	minDtPolicy := flag.String("min-dt-policy", "clamp", "samples closer than --min-dt to the previous one: clamp their time step to --min-dt, or skip them")
+	nanPolicy := flag.String("nan-policy", "keep", "records whose jerk is NaN or infinite from corrupt input: keep them with jerk_valid false, or skip them")
+	contactsPath := flag.String("contacts", "", "also write an event to this CSV file, or - for stdout, whenever two players come within --contact-distance")
+	flag.Float64Var(&cfg.ContactRadius, "contact-distance", 1, "distance in meters between two players that counts as a contact for --contacts")
+	flag.Float64Var(&cfg.MinJerk, "min-jerk", 0, "only write records whose jerk is at least this value (0 writes everything)")
+	startTime := flag.Float64("start-time", math.Inf(-1), "skip frames whose game_clock is before this time")
+	endTime := flag.Float64("end-time", math.Inf(1), "skip frames whose game_clock is after this time")
+	flag.Var(sessions, "session", "only process frames from this session ID (repeatable)")
+	flag.Var(users, "user", "only process players with this user ID (repeatable)")
+	var outputPath, format string
+	flag.StringVar(&outputPath, "o", "", "output file, or - for stdout (shorthand for --output; default features.<format>)")
+	flag.StringVar(&outputPath, "output", "", "output file, or - for stdout (default features.<format>)")
+	flag.StringVar(&format, "format", "parquet", "output format: parquet, arrow, csv, or jsonl")
+	includeColumns := flag.String("columns", "", "comma-separated list of the columns to write, in order, replacing the columns chosen by the feature flags")
+	excludeColumns := flag.String("exclude-columns", "", "comma-separated list of columns to leave out of the output")
+	var tees teeFlag
+	flag.Var(&tees, "tee", "also write the records in another format to a path, as format=path, in the same pass (repeatable)")
+	compression := flag.String("compression", "snappy", "parquet compression codec: snappy, gzip, zstd, or none")
+	var wopts writerOptions
+	flag.Int64Var(&wopts.rowGroupSize, "row-group-size", 128*1024*1024, "target parquet row group size in bytes")
+	flag.Int64Var(&wopts.np, "parquet-np", 4, "number of goroutines the parquet writer uses to encode columns")
+	flag.BoolVar(&wopts.noHeader, "no-header", false, "omit the header row from csv output, for appending to or concatenating files")
+	partitionBySession := flag.Bool("partition-by-session", false, "write one output file per session, named after the output path and session ID")
+	flag.IntVar(&cfg.MaxErrors, "max-errors", 0, "abort when more than this many input lines fail to parse (0 never aborts)")
+	verbose := flag.Bool("verbose", false, "report every input line that fails to parse (same as --log-level debug)")
+	flag.IntVar(&cfg.ProgressEvery, "progress-every", 100000, "log progress after every N input frames (0 disables)")
+	progress := flag.Bool("progress", false, "log progress even when stderr is not a terminal")
+	logLevel := flag.String("log-level", "info", "lowest level to log: debug, info, warn, or error")
+	logFormat := flag.String("log-format", "text", "log format on stderr: text or json")
+	listen := flag.String("listen", "", "read frames from the first TCP connection accepted on this address, such as :9000, instead of stdin")
+	follow := flag.Bool("follow", false, "keep reading the input file as it grows, like tail -f (csv or jsonl output only)")
+	archive := flag.Bool("archive", false, "treat every input file as a tar archive, optionally gzip- or zstd-compressed, and process its .json and .jsonl members")
+	filesFrom := flag.String("files-from", "", "also read input file paths, one per line, from this manifest file, or - for stdin")
+	workers := flag.Int("workers", 1, "number of input files to process in parallel, each with its own player state")
+	dryRun := flag.Bool("dry-run", false, "process the input without writing any output, reporting how many records would be written")
+	partitionByUser := flag.Bool("partition-by-user", false, "write one output file per user, named after the output path and user ID; with --partition-by-session, one per session and user")
+	loadState := flag.String("load-state", "", "resume from the player state that --save-state wrote to this file")
+	saveState := flag.String("save-state", "", "save every player's state to this file when the run ends, for resuming with --load-state")
+	buffer := flag.Int("buffer", 0, "hand records to a separate writer goroutine through a buffer of this many records, blocking when it is full (0 writes them inline)")
+	sortRecords := flag.Bool("sort", false, "hold every record in memory and write them sorted by session, user, and time (not with --follow or --listen)")
+	pretty := flag.Bool("pretty", false, "print a table summarizing the run to stderr when it finishes")
+	sessionReport := flag.Bool("session-report", false, "print each session's time range, duration, frame count, and average frame rate to stdout")
+	idleSpeed := flag.Float64("idle-speed", 0, "with --stats, also report each player's time moving and idle, counting speeds below this many m/s as idle (0 disables)")
+	printStats := flag.Bool("stats", false, "print per-player jerk statistics to stdout instead of writing records, or in addition to them when -o is given")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to this file, for go tool pprof")
+	memProfile := flag.String("memprofile", "", "write a heap profile to this file when the run finishes, for go tool pprof")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [input.jsonl ...]\n\nReads stdin when no input files or --files-from manifest are given.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-				// Calculate acceleration from velocity change
-				// Note: This is a finite difference approximation without time normalization.
-				// For proper physics calculations, this should be divided by deltaTime.
-				// The current implementation assumes uniform time steps between frames.
-				currentAccel := player.Velocity.Sub(state.LastVelocity)
-
-				if state.HasPrevious {
-					// Calculate jerk as the magnitude of change in acceleration
-					accelChange := currentAccel.Sub(state.LastAccel)
-					jerk := accelChange.Magnitude()
-
-					// Record the jerk value
-					records = append(records, JerkRecord{
-						SessionID: frame.SessionID,
-						UserID:    player.UserID,
-						Time:      frame.Time,
-						Jerk:      jerk,
-					})
-				}
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	cfg.Sessions, cfg.Users = sessions, users
+	if *wide {
+		// Feature flags given explicitly override the wide defaults, so
+		// unwanted columns can still be left out
+		explicit := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if !explicit["derivatives"] {
+			cfg.Derivatives = 3
+		}
+		if !explicit["per-axis"] {
+			cfg.PerAxis = true
+		}
+		if !explicit["display-name"] {
+			cfg.DisplayNames = true
+		}
+		if !explicit["include-orientation"] {
+			cfg.Orientation = true
+		}
+		if !explicit["include-kinematics"] {
+			cfg.Kinematics = true
+		}
+		if !explicit["team-swap-detection"] {
+			cfg.TeamSwaps = true
+		}
+	}
+	if *verbose {
+		*logLevel = "debug"
+	}
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	cfg.OnParseError = func(line int, err error) {
+		var ferr *evrplay.FieldError
+		if errors.As(err, &ferr) {
+			// Only --strict finds these, so they are always worth reporting
+			slog.Warn("skipping line that does not match the schema", "line", line, "field", ferr.Field)
+			return
+		}
+		slog.Debug("skipping line that is not valid JSON", "line", line, "error", err)
+	}
+	if *progress || isTerminal(os.Stderr) {
+		start := time.Now()
+		cfg.OnProgress = func(s evrplay.Stats) {
+			elapsed := time.Since(start)
+			slog.Info("progress", "frames", s.Lines, "records", s.Records,
+				"elapsed", elapsed.Round(time.Second).String(),
+				"frames_per_sec", math.Round(float64(s.Lines)/elapsed.Seconds()))
+		}
+	}
 
-				// Update state
-				state.LastPosition = player.Position
-				state.LastVelocity = player.Velocity
-				state.LastAccel = currentAccel
-				state.HasPrevious = true
+	if cfg.Schema, err = evrplay.ParseSchema(*schema); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if cfg.NaNPolicy, err = evrplay.ParseNaNPolicy(*nanPolicy); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if cfg.MinDtPolicy, err = evrplay.ParseMinDtPolicy(*minDtPolicy); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if cfg.MinDt < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --min-dt must not be negative\n")
+		os.Exit(2)
+	}
+	if cfg.Diff, err = evrplay.ParseDiff(*diff); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if *subtractGravity {
+		g := evrplay.Vec3(gravity)
+		cfg.Gravity = &g
+	}
+	if !math.IsInf(*startTime, -1) || !math.IsInf(*endTime, 1) {
+		if !(*startTime <= *endTime) {
+			fmt.Fprintf(os.Stderr, "Error: --start-time must not be after --end-time\n")
+			os.Exit(2)
+		}
+		cfg.Window = &evrplay.TimeWindow{Start: *startTime, End: *endTime}
+		if cfg.Schema == evrplay.SchemaOfficial {
+			// The window applies to frame times, which negate the official
+			// game_clock so that they increase
+			cfg.Window = &evrplay.TimeWindow{Start: -*endTime, End: -*startTime}
+		}
+	}
+	if cfg.Derivatives < 1 || cfg.Derivatives > 3 {
+		fmt.Fprintf(os.Stderr, "Error: --derivatives must be 1, 2, or 3\n")
+		os.Exit(2)
+	}
+
+	if cfg.MaxPlayers < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --max-players must not be negative\n")
+		os.Exit(2)
+	}
+	if cfg.SeedFrames < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --seed-frames must not be negative\n")
+		os.Exit(2)
+	}
+	if cfg.EveryNth < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --every-nth must be at least 1\n")
+		os.Exit(2)
+	}
+
+	if cfg.SmoothWindow < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --smooth-window must be at least 1\n")
+		os.Exit(2)
+	}
+
+	if cfg.RejoinGap < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --rejoin-gap must not be negative\n")
+		os.Exit(2)
+	}
+
+	if cfg.ResampleHz < 0 || cfg.ResampleMaxGap <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --resample-hz must not be negative and --resample-max-gap must be positive\n")
+		os.Exit(2)
+	}
+	if cfg.UnitsScale <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --units-scale must be positive\n")
+		os.Exit(2)
+	}
+	if cfg.MaxSpeed < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --max-speed must not be negative\n")
+		os.Exit(2)
+	}
+
+	if cfg.Limit < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --limit must not be negative\n")
+		os.Exit(2)
+	}
+	if cfg.ProgressEvery < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --progress-every must not be negative\n")
+		os.Exit(2)
+	}
+	if cfg.MaxErrors < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --max-errors must not be negative\n")
+		os.Exit(2)
+	}
+
+	inputs := flag.Args()
+	if *filesFrom != "" {
+		paths, err := readManifest(*filesFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: reading --files-from: %v\n", err)
+			os.Exit(1)
+		}
+		inputs = append(inputs, paths...)
+	}
+
+	if *workers < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --workers must be at least 1\n")
+		os.Exit(2)
+	}
+
+	if *follow {
+		switch {
+		case len(inputs) != 1:
+			fmt.Fprintf(os.Stderr, "Error: --follow needs exactly one input file\n")
+			os.Exit(2)
+		case compressionByName(inputs[0]) != "" || *archive:
+			fmt.Fprintf(os.Stderr, "Error: --follow cannot read compressed input or archives\n")
+			os.Exit(2)
+		case format == "parquet" || format == "arrow":
+			fmt.Fprintf(os.Stderr, "Error: --follow needs --format csv or jsonl, since %s output is unreadable until finalized\n", format)
+			os.Exit(2)
+		}
+		for _, t := range tees {
+			if t.format == "parquet" || t.format == "arrow" {
+				fmt.Fprintf(os.Stderr, "Error: --follow needs csv or jsonl --tee outputs, since %s output is unreadable until finalized\n", t.format)
+				os.Exit(2)
 			}
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
-		os.Exit(1)
+	if *contactsPath != "" && (*workers > 1 || cfg.ContactRadius <= 0) {
+		fmt.Fprintf(os.Stderr, "Error: --contacts needs a positive --contact-distance and cannot be combined with --workers\n")
+		os.Exit(2)
+	}
+
+	if *sortRecords && (*follow || *listen != "") {
+		fmt.Fprintf(os.Stderr, "Error: --sort needs bounded input, so it cannot be combined with --follow or --listen\n")
+		os.Exit(2)
+	}
+
+	var listener net.Listener
+	if *listen != "" {
+		if len(inputs) > 0 || *filesFrom != "" || *follow || *archive {
+			fmt.Fprintf(os.Stderr, "Error: --listen cannot be combined with input files, --archive, or --follow\n")
+			os.Exit(2)
+		}
+		var err error
+		if listener, err = net.Listen("tcp", *listen); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer listener.Close()
+	}
+
+	statsOnly := *printStats && outputPath == ""
+	if outputPath == "" {
+		outputPath = "features." + format
+	}
+	if (*printStats || *sessionReport) && outputPath == "-" {
+		fmt.Fprintf(os.Stderr, "Error: --stats and --session-report print to stdout, so records cannot also be written there\n")
+		os.Exit(2)
+	}
+	outputPaths := map[string]bool{}
+	if !statsOnly {
+		outputPaths[outputPath] = true
+	}
+	for _, t := range tees {
+		if outputPaths[t.path] || (t.path == "-" && (*printStats || *sessionReport)) {
+			fmt.Fprintf(os.Stderr, "Error: --tee %s=%s writes to a path that is already an output\n", t.format, t.path)
+			os.Exit(2)
+		}
+		outputPaths[t.path] = true
+	}
+	if *contactsPath != "" && (outputPaths[*contactsPath] || (*contactsPath == "-" && (*printStats || *sessionReport))) {
+		fmt.Fprintf(os.Stderr, "Error: --contacts %s writes to a path that is already an output\n", *contactsPath)
+		os.Exit(2)
+	}
+
+	codec, err := parseCompression(*compression)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	wopts.compression = codec
+	wopts.metadata = fileMetadata(os.Args[1:])
+	if wopts.rowGroupSize <= 0 || wopts.np <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --row-group-size and --parquet-np must be positive\n")
+		os.Exit(2)
+	}
+	if *idleSpeed < 0 || (*idleSpeed > 0 && !*printStats) {
+		fmt.Fprintf(os.Stderr, "Error: --idle-speed must not be negative and needs --stats\n")
+		os.Exit(2)
+	}
+	if (*loadState != "" || *saveState != "") && *workers > 1 && len(inputs) > 1 {
+		fmt.Fprintf(os.Stderr, "Error: --load-state and --save-state cannot be combined with --workers\n")
+		os.Exit(2)
+	}
+	if *buffer < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --buffer must not be negative\n")
+		os.Exit(2)
+	}
+
+	cols := evrplay.OutputColumns(cfg)
+	if *includeColumns != "" {
+		cols, err = evrplay.SelectColumns(splitList(*includeColumns))
+	}
+	if err == nil && *excludeColumns != "" {
+		cols, err = evrplay.ExcludeColumns(cols, splitList(*excludeColumns))
+	}
+	if err == nil && len(cols) == 0 {
+		err = errors.New("no output columns are left")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	openOutput := func(path string) (evrplay.RecordSink, error) {
+		return newRecordWriter(format, path, cols, wopts)
+	}
+
+	var checkpoint *evrplay.Checkpoint
+	if *loadState != "" {
+		if checkpoint, err = loadCheckpoint(*loadState); err != nil {
+			slog.Error("loading state", "path", *loadState, "error", err)
+			os.Exit(1)
+		}
 	}
 
-	// Write records to parquet file
-	if len(records) > 0 {
-		if err := writeParquet(records); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing parquet: %v\n", err)
+	var sink evrplay.RecordSink
+	var partitions *partitionWriter
+	var summary *evrplay.SummarySink
+	if *printStats {
+		summary = evrplay.NewSummarySink()
+		summary.IdleSpeed = *idleSpeed
+	}
+	var report *evrplay.SessionReport
+	if *sessionReport {
+		report = evrplay.NewSessionReport()
+		cfg.OnFrame = report.Add
+	}
+	if statsOnly {
+		sink = summary
+	} else if *dryRun {
+		sink = discardSink{}
+	} else if *partitionBySession || *partitionByUser {
+		if outputPath == "-" {
+			fmt.Fprintf(os.Stderr, "Error: --partition-by-session and --partition-by-user need an output file path, not stdout\n")
+			os.Exit(2)
+		}
+		key := sessionPartition
+		switch {
+		case *partitionBySession && *partitionByUser:
+			key = sessionUserPartition
+		case *partitionByUser:
+			key = userPartition
+		}
+		partitions = newPartitionWriter(outputPath, key, openOutput)
+		sink = partitions
+	} else {
+		sink, err = openOutput(outputPath)
+	}
+	if err != nil {
+		slog.Error("preparing output", "path", outputPath, "error", err)
+		os.Exit(1)
+	}
+	if summary != nil && !statsOnly {
+		sink = multiSink{sink, summary}
+	}
+	if len(tees) > 0 && !*dryRun {
+		// Every output is closed, and so finalized, even when another fails
+		outputs := multiSink{sink}
+		for _, t := range tees {
+			out, err := newRecordWriter(t.format, t.path, cols, wopts)
+			if err != nil {
+				slog.Error("preparing output", "path", t.path, "error", err)
+				outputs.Close()
+				os.Exit(1)
+			}
+			outputs = append(outputs, out)
+		}
+		sink = outputs
+	}
+	if *buffer > 0 {
+		sink = newBufferedSink(sink, *buffer)
+	}
+	if *sortRecords {
+		sink = &sortedSink{sink: sink}
+	}
+	if *contactsPath != "" && !*dryRun {
+		contacts, err := newContactWriter(*contactsPath, !wopts.noHeader)
+		if err != nil {
+			slog.Error("preparing output", "path", *contactsPath, "error", err)
+			sink.Close()
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "Successfully wrote %d records to features.parquet\n", len(records))
+		cfg.OnContact = contacts.WriteContact
+		sink = multiSink{sink, contacts}
+	}
+
+	// The first SIGINT or SIGTERM stops reading input, so the records
+	// computed so far are still finalized; a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	interrupted := func(err error) bool {
+		return ctx.Err() != nil && errors.Is(err, ctx.Err())
+	}
+
+	// Profiles are written on every exit after the run starts, including an
+	// interrupted one
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+	if err != nil {
+		slog.Error("starting profile", "path", *cpuProfile, "error", err)
+		sink.Close()
+		os.Exit(1)
+	}
+	// exit ends the run with code once the profiles are written
+	exit := func(code int) {
+		stopProfiles()
+		os.Exit(code)
+	}
+
+	failed := false
+	start := time.Now()
+	// fileFailed reports an input that could not be read completely and
+	// ends the run when the failure affects the output as a whole
+	fileFailed := func(path string, err error) {
+		if interrupted(err) {
+			return
+		}
+		slog.Error("reading input", "path", path, "error", err)
+		var serr *evrplay.SinkError
+		if errors.As(err, &serr) || errors.Is(err, evrplay.ErrTooManyParseErrors) {
+			sink.Close()
+			exit(1)
+		}
+		failed = true
+	}
+
+	// flushOutput keeps the output current while a live input is idle
+	flushOutput := func() error {
+		if err := flushSink(sink); err != nil {
+			return &evrplay.SinkError{Err: err}
+		}
+		return nil
+	}
+
+	newProcessor := func() *evrplay.Processor {
+		p := evrplay.NewProcessor(cfg, sink)
+		if checkpoint != nil {
+			p.Restore(checkpoint)
+		}
+		return p
+	}
+
+	// finish writes the records a processor still holds back once its
+	// input is exhausted, then saves its state if asked to
+	finish := func(p *evrplay.Processor) {
+		if err := p.Flush(); err != nil {
+			slog.Error("writing output", "error", err)
+			sink.Close()
+			exit(1)
+		}
+		if *saveState == "" {
+			return
+		}
+		if err := saveCheckpoint(*saveState, p.Checkpoint()); err != nil {
+			slog.Error("saving state", "path", *saveState, "error", err)
+			failed = true
+		}
+	}
+
+	readInput := inputReader(processFile)
+	if *archive {
+		readInput = processArchive
+	}
+
+	var stats evrplay.Stats
+	if listener != nil {
+		slog.Info("listening", "addr", listener.Addr().String())
+		p := newProcessor()
+		// A dropped connection still leaves a finalized output behind
+		if err := processConnection(ctx, p, listener, flushOutput); err != nil {
+			fileFailed("connection", err)
+		}
+		finish(p)
+		stats = p.Stats()
+	} else if *follow {
+		// Runs until interrupted
+		p := newProcessor()
+		if err := followFile(ctx, p, inputs[0], flushOutput); err != nil {
+			fileFailed(inputs[0], err)
+		}
+		finish(p)
+		stats = p.Stats()
+	} else if *workers > 1 && len(inputs) > 1 {
+		// Results are written in input order, so the output matches a
+		// sequential run whenever no session spans several files
+		written := 0
+		limitReached := func() bool { return cfg.Limit > 0 && written >= cfg.Limit }
+		for _, res := range startFileWorkers(ctx, cfg, inputs, *workers, readInput) {
+			<-res.done
+			stats.Add(res.stats)
+			for i := range res.records {
+				if limitReached() {
+					break
+				}
+				if err := sink.Write(&res.records[i]); err != nil {
+					fileFailed(res.path, &evrplay.SinkError{Err: err})
+				}
+				written++
+			}
+			res.release()
+			if res.err != nil {
+				fileFailed(res.path, res.err)
+			}
+			if limitReached() || ctx.Err() != nil {
+				break
+			}
+		}
+		stats.Records = written
 	} else {
-		fmt.Fprintf(os.Stderr, "No records to write\n")
+		p := newProcessor()
+		// An empty manifest means there is nothing to read, not stdin
+		if len(inputs) == 0 && *filesFrom == "" {
+			if err := processStdin(ctx, p); err != nil && !interrupted(err) {
+				slog.Error("reading input", "path", "stdin", "error", err)
+				sink.Close()
+				exit(1)
+			}
+		}
+		for _, path := range inputs {
+			if p.Done() || ctx.Err() != nil {
+				break
+			}
+			if err := readInput(ctx, p, path); err != nil {
+				fileFailed(path, err)
+			}
+		}
+		finish(p)
+		stats = p.Stats()
+	}
+
+	if stats.ParseErrors > 0 {
+		slog.Warn("some lines failed to parse", "failed", stats.ParseErrors, "lines", stats.Lines)
+	}
+	if stats.Oversized > 0 {
+		slog.Warn("skipped frames with too many players", "frames", stats.Oversized, "max_players", cfg.MaxPlayers)
+	}
+	if stats.Duplicates > 0 {
+		slog.Info("collapsed duplicate samples", "samples", stats.Duplicates)
+	}
+	if stats.ShortDt > 0 {
+		if cfg.MinDtPolicy == evrplay.MinDtSkip {
+			slog.Info("skipped samples closer than --min-dt", "samples", stats.ShortDt, "min_dt", cfg.MinDt)
+		} else {
+			slog.Info("clamped time steps to --min-dt", "samples", stats.ShortDt, "min_dt", cfg.MinDt)
+		}
+	}
+	if cfg.DropOutOfOrder {
+		slog.Info("dropped out-of-order frames", "frames", stats.OutOfOrder)
+	}
+	if stats.NonFinite > 0 {
+		if cfg.NaNPolicy == evrplay.NaNSkip {
+			slog.Warn("skipped records with a NaN or infinite jerk", "records", stats.NonFinite)
+		} else {
+			slog.Warn("kept records with a NaN or infinite jerk as invalid", "records", stats.NonFinite)
+		}
+	}
+	if cfg.SeedFrames > 0 {
+		slog.Info("suppressed records of seed frames", "records", stats.Seeded, "seed_frames", cfg.SeedFrames)
+	}
+	if cfg.MinJerk > 0 {
+		slog.Info("filtered records below --min-jerk", "records", stats.BelowJerk, "min_jerk", cfg.MinJerk)
+	}
+
+	if err := sink.Close(); err != nil {
+		slog.Error("writing output", "format", format, "error", err)
+		exit(1)
+	}
+	if ctx.Err() != nil {
+		slog.Warn("interrupted, so the output only holds the records computed so far", "records", stats.Records)
+	}
+	if summary != nil {
+		printSummaries(os.Stdout, summary.Summaries(), summary.IdleSpeed > 0)
+	}
+	if report != nil {
+		if summary != nil {
+			fmt.Println()
+		}
+		printSessionReport(os.Stdout, report.Sessions())
+	}
+	switch {
+	case *dryRun:
+		slog.Info("dry run finished", "records", stats.Records, "lines", stats.Lines, "parse_errors", stats.ParseErrors)
+		if stats.Records == 0 {
+			// Usually the input is in a different schema than expected
+			failed = true
+		}
+	case statsOnly:
+	case stats.Records > 0:
+		dest := outputName(outputPath)
+		if partitions != nil {
+			dest = fmt.Sprintf("%d files", len(partitions.writers))
+		}
+		for _, t := range tees {
+			dest += ", " + outputName(t.path)
+		}
+		slog.Info("wrote records", "records", stats.Records, "output", dest)
+	default:
+		slog.Warn("no records to write")
+	}
+
+	if *pretty {
+		printRunSummary(os.Stderr, stats, time.Since(start))
+	}
+	stopProfiles()
+
+	if ctx.Err() != nil {
+		exit(130)
+	}
+	if failed {
+		exit(1)
 	}
 }
 
-func writeParquet(records []JerkRecord) error {
-	fw, err := local.NewLocalFileWriter("features.parquet")
+// loadCheckpoint reads the checkpoint saved at path
+func loadCheckpoint(path string) (*evrplay.Checkpoint, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return nil, err
 	}
-	defer fw.Close()
+	defer f.Close()
+	return evrplay.ReadCheckpoint(bufio.NewReader(f))
+}
 
-	pw, err := writer.NewParquetWriter(fw, new(JerkRecord), 4)
+// saveCheckpoint writes c to path, replacing it only once the checkpoint
+// is complete so an interrupted save leaves the previous one intact
+func saveCheckpoint(path string, c *evrplay.Checkpoint) error {
+	if err := prepareOutput(path); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = c.Encode(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create parquet writer: %w", err)
+		os.Remove(tmp)
+		return err
 	}
-	defer pw.WriteStop()
+	return os.Rename(tmp, path)
+}
 
-	for _, record := range records {
-		if err := pw.Write(record); err != nil {
-			return fmt.Errorf("failed to write record: %w", err)
+// printSummaries writes per-player jerk statistics as an aligned table,
+// followed by the time moving and idle when idle is set
+func printSummaries(w io.Writer, summaries []*evrplay.PlayerSummary, idle bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := "sessionid\tuserid\tsamples\tmin\tp50\tp90\tp99\tmean\tmax\tstddev\t"
+	if idle {
+		header += "moving_s\tidle_s\tmoving_pct\tidle_pct\t"
+	}
+	fmt.Fprintln(tw, header)
+	for _, s := range summaries {
+		min, mean, max := s.Min, s.Mean(), s.Max
+		if s.Count == 0 {
+			// Only tracked for the idle statistics
+			min, mean, max = math.NaN(), math.NaN(), math.NaN()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t",
+			s.Key.SessionID, s.Key.UserID, s.Count, min, s.P50(), s.P90(), s.P99(), mean, max, s.StdDev())
+		if idle {
+			f := s.IdleFraction()
+			fmt.Fprintf(tw, "%.3f\t%.3f\t%.1f\t%.1f\t", s.Moving, s.Idle, 100*(1-f), 100*f)
 		}
+		fmt.Fprintln(tw)
 	}
+	tw.Flush()
+}
 
-	return nil
+// printSessionReport writes the frame timing of every session as an aligned
+// table
+func printSessionReport(w io.Writer, sessions []evrplay.SessionTiming) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "sessionid\tframes\tmin_time\tmax_time\tduration\tframe_rate\t")
+	for _, s := range sessions {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%.3f\t%.3f\t%.2f\t\n",
+			s.SessionID, s.Frames, s.MinTime, s.MaxTime, s.Duration(), s.FrameRate())
+	}
+	tw.Flush()
+}
+
+// printRunSummary writes the counters of a finished run as an aligned table
+func printRunSummary(w io.Writer, stats evrplay.Stats, elapsed time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rows := []struct {
+		name  string
+		value interface{}
+	}{
+		{"lines read", stats.Lines},
+		{"parse errors", stats.ParseErrors},
+		{"frames read", stats.Frames},
+		{"frames skipped", stats.SkippedFrames},
+		{"frames over max players", stats.Oversized},
+		{"duplicate samples", stats.Duplicates},
+		{"samples out of order", stats.OutOfOrder},
+		{"samples below min dt", stats.ShortDt},
+		{"records from seed frames", stats.Seeded},
+		{"records below min jerk", stats.BelowJerk},
+		{"records with non-finite jerk", stats.NonFinite},
+		{"records written", stats.Records},
+		{"sessions", stats.Sessions},
+		{"players", stats.Players},
+		{"wall time", elapsed.Round(time.Millisecond)},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%v\n", row.name, row.value)
+	}
+	tw.Flush()
+}
+
+// fileMetadata returns the key-value metadata that records which tool
+// version and arguments produced an output file
+func fileMetadata(args []string) map[string]string {
+	encoded, _ := json.Marshal(args)
+	return map[string]string{
+		"tool_version":   version,
+		"schema_version": strconv.Itoa(evrplay.OutputSchemaVersion),
+		"args":           string(encoded),
+	}
 }
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/thesprockee/evr-playspace/evrplay"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// writerOptions tunes the output writers
+type writerOptions struct {
+	compression  parquet.CompressionCodec
+	rowGroupSize int64
+	np           int64
+	metadata     map[string]string // file-level key-value metadata
+	noHeader     bool              // omit the csv header row
+}
+
+// compressionCodecs maps --compression names to parquet codecs
+var compressionCodecs = map[string]parquet.CompressionCodec{
+	"snappy": parquet.CompressionCodec_SNAPPY,
+	"gzip":   parquet.CompressionCodec_GZIP,
+	"zstd":   parquet.CompressionCodec_ZSTD,
+	"none":   parquet.CompressionCodec_UNCOMPRESSED,
+}
+
+func parseCompression(name string) (parquet.CompressionCodec, error) {
+	codec, ok := compressionCodecs[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown compression codec %q (want snappy, gzip, zstd, or none)", name)
+	}
+	return codec, nil
+}
+
+// newRecordWriter creates a writer for the named output format
+func newRecordWriter(format, path string, cols []evrplay.Column, wopts writerOptions) (evrplay.RecordSink, error) {
+	switch format {
+	case "parquet":
+		if path != "-" {
+			if err := prepareOutput(path); err != nil {
+				return nil, err
+			}
+		}
+		return &parquetWriter{path: path, cols: cols, opts: wopts}, nil
+	case "arrow":
+		if path == "-" {
+			return nil, fmt.Errorf("arrow output cannot be written to stdout")
+		}
+		if err := prepareOutput(path); err != nil {
+			return nil, err
+		}
+		return newArrowWriter(path, cols, wopts.metadata)
+	case "csv":
+		out, err := createOutput(path)
+		if err != nil {
+			return nil, err
+		}
+		return newCSVWriter(out, cols, !wopts.noHeader)
+	case "jsonl":
+		out, err := createOutput(path)
+		if err != nil {
+			return nil, err
+		}
+		return newJSONLWriter(out, cols), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// outputName describes an output path for messages
+func outputName(path string) string {
+	if path == "-" {
+		return "stdout"
+	}
+	return path
+}
+
+// createOutput opens path for writing, treating - as stdout
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	if err := prepareOutput(path); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// nopWriteCloser keeps stdout open when an output is closed
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// flusher is implemented by sinks that buffer output and can write it out
+// early, such as while waiting for a followed input to grow
+type flusher interface {
+	Flush() error
+}
+
+// flushSink flushes sink if it buffers output
+func flushSink(sink evrplay.RecordSink) error {
+	if f, ok := sink.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// discardSink drops every record, for runs that only validate the input
+type discardSink struct{}
+
+func (discardSink) Write(*evrplay.JerkRecord) error { return nil }
+func (discardSink) Close() error                    { return nil }
+
+// multiSink writes every record to each of its sinks
+type multiSink []evrplay.RecordSink
+
+func (m multiSink) Write(r *evrplay.JerkRecord) error {
+	for _, sink := range m {
+		if err := sink.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiSink) Flush() error {
+	for _, sink := range m {
+		if err := flushSink(sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every sink, returning the first error encountered
+func (m multiSink) Close() error {
+	var firstErr error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// sortedSink holds every record until Close, then writes them to sink
+// sorted by session, user, and time. Records that tie keep their processing
+// order.
+type sortedSink struct {
+	recordBuffer
+	sink evrplay.RecordSink
+}
+
+func (s *sortedSink) Close() error {
+	sort.SliceStable(s.records, func(i, j int) bool {
+		a, b := &s.records[i], &s.records[j]
+		if a.SessionID != b.SessionID {
+			return a.SessionID < b.SessionID
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.Time < b.Time
+	})
+	for i := range s.records {
+		if err := s.sink.Write(&s.records[i]); err != nil {
+			s.sink.Close()
+			return err
+		}
+	}
+	return s.sink.Close()
+}
+
+// bufferedSink hands records through a channel of up to size records to a
+// goroutine that writes them to sink, so decoding and computing overlap with
+// encoding and writing. Write blocks while the channel is full, which holds
+// the processor back to the pace of a slow sink. A write error is returned
+// by the Write calls after it and by Close.
+type bufferedSink struct {
+	sink evrplay.RecordSink
+	ops  chan bufferedOp
+	done chan struct{}
+	once sync.Once
+
+	mu  sync.Mutex
+	err error // first error from sink.Write
+}
+
+// bufferedOp is a record to write, or a flush request when flushed is set
+type bufferedOp struct {
+	record  evrplay.JerkRecord
+	flushed chan error
+}
+
+func newBufferedSink(sink evrplay.RecordSink, size int) *bufferedSink {
+	s := &bufferedSink{sink: sink, ops: make(chan bufferedOp, size), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+func (s *bufferedSink) run() {
+	defer close(s.done)
+	for op := range s.ops {
+		err := s.failed()
+		switch {
+		case op.flushed != nil:
+			if err == nil {
+				err = flushSink(s.sink)
+			}
+			op.flushed <- err
+		case err == nil:
+			if err := s.sink.Write(&op.record); err != nil {
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (s *bufferedSink) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *bufferedSink) Write(r *evrplay.JerkRecord) error {
+	if err := s.failed(); err != nil {
+		return err
+	}
+	s.ops <- bufferedOp{record: *r}
+	return nil
+}
+
+// Flush waits for the records written so far to reach sink and flushes it
+func (s *bufferedSink) Flush() error {
+	flushed := make(chan error, 1)
+	s.ops <- bufferedOp{flushed: flushed}
+	return <-flushed
+}
+
+// Close writes the buffered records and closes sink
+func (s *bufferedSink) Close() error {
+	s.once.Do(func() { close(s.ops) })
+	<-s.done
+	err := s.sink.Close()
+	if werr := s.failed(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// partitionWriter routes records to one output per partition key, opening
+// each output the first time its key appears. Every output stays open until
+// Close, so keys may interleave freely in the input.
+type partitionWriter struct {
+	base    string
+	key     func(r *evrplay.JerkRecord) string
+	open    func(path string) (evrplay.RecordSink, error)
+	writers map[string]evrplay.RecordSink
+	names   map[string]string // file suffix -> key, to avoid collisions
+}
+
+func newPartitionWriter(base string, key func(r *evrplay.JerkRecord) string, open func(path string) (evrplay.RecordSink, error)) *partitionWriter {
+	return &partitionWriter{
+		base:    base,
+		key:     key,
+		open:    open,
+		writers: make(map[string]evrplay.RecordSink),
+		names:   make(map[string]string),
+	}
+}
+
+// sessionPartition partitions records by session
+func sessionPartition(r *evrplay.JerkRecord) string {
+	return r.SessionID
+}
+
+// userPartition partitions records by user
+func userPartition(r *evrplay.JerkRecord) string {
+	return r.UserID
+}
+
+// sessionUserPartition partitions records by session and user
+func sessionUserPartition(r *evrplay.JerkRecord) string {
+	return r.SessionID + "_" + r.UserID
+}
+
+func (w *partitionWriter) Write(r *evrplay.JerkRecord) error {
+	k := w.key(r)
+	out, ok := w.writers[k]
+	if !ok {
+		var err error
+		if out, err = w.open(w.partitionPath(k)); err != nil {
+			return err
+		}
+		w.writers[k] = out
+	}
+	return out.Write(r)
+}
+
+// partitionPath inserts the sanitized key before the extension of the base
+// path, so features.parquet becomes features_<key>.parquet
+func (w *partitionWriter) partitionPath(key string) string {
+	name := sanitizeFileName(key)
+	for i := 2; ; i++ {
+		if owner, taken := w.names[name]; !taken || owner == key {
+			break
+		}
+		name = fmt.Sprintf("%s_%d", sanitizeFileName(key), i)
+	}
+	w.names[name] = key
+
+	ext := filepath.Ext(w.base)
+	return strings.TrimSuffix(w.base, ext) + "_" + name + ext
+}
+
+func (w *partitionWriter) Flush() error {
+	for _, out := range w.writers {
+		if err := flushSink(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every partition, returning the first error encountered
+func (w *partitionWriter) Close() error {
+	var firstErr error
+	for _, out := range w.writers {
+		if err := out.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// sanitizeFileName replaces characters that are unsafe in file names
+func sanitizeFileName(s string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+	if safe == "" || strings.Trim(safe, ".") == "" {
+		safe = "_" + safe
+	}
+	return safe
+}
+
+// parquetTag returns the parquet-go metadata string for a column
+func parquetTag(c evrplay.Column) string {
+	tag := fmt.Sprintf("name=%s, type=%s", c.Name, c.Type)
+	if c.Type == "BYTE_ARRAY" {
+		tag += ", convertedtype=UTF8"
+	}
+	return tag
+}
+
+// parquetWriter streams records into a parquet file, or to stdout when
+// its path is -. The file is created on the first record, so a run without
+// records leaves no file behind, and is finalized by Close.
+type parquetWriter struct {
+	path   string
+	cols   []evrplay.Column
+	opts   writerOptions
+	fw     source.ParquetFile
+	pw     *writer.CSVWriter
+	stdout *bufio.Writer // buffers the stream when writing to stdout
+}
+
+func (w *parquetWriter) Write(r *evrplay.JerkRecord) error {
+	if w.pw == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	// The writer buffers rows until a row group is flushed, so each record
+	// needs its own slice
+	row := make([]interface{}, len(w.cols))
+	for i, c := range w.cols {
+		row[i] = c.Value(r)
+	}
+	if err := w.pw.Write(row); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+func (w *parquetWriter) open() error {
+	var fw source.ParquetFile
+	if w.path == "-" {
+		// Parquet is written front to back, footer last, so it streams
+		// to a pipe without seeking
+		w.stdout = bufio.NewWriter(os.Stdout)
+		fw = writerfile.NewWriterFile(w.stdout)
+	} else {
+		var err error
+		if fw, err = local.NewLocalFileWriter(w.path); err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+	}
+
+	md := make([]string, len(w.cols))
+	for i, c := range w.cols {
+		md[i] = parquetTag(c)
+	}
+
+	pw, err := writer.NewCSVWriter(md, fw, w.opts.np)
+	if err != nil {
+		fw.Close()
+		return fmt.Errorf("failed to create parquet writer: %w", err)
+	}
+	pw.CompressionType = w.opts.compression
+	pw.RowGroupSize = w.opts.rowGroupSize
+	for _, k := range sortedKeys(w.opts.metadata) {
+		v := w.opts.metadata[k]
+		pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: k, Value: &v})
+	}
+
+	w.fw, w.pw = fw, pw
+	return nil
+}
+
+func (w *parquetWriter) Close() error {
+	if w.pw == nil {
+		return nil
+	}
+	if err := w.pw.WriteStop(); err != nil {
+		w.fw.Close()
+		return fmt.Errorf("failed to finalize parquet file: %w", err)
+	}
+	if w.stdout != nil {
+		if err := w.stdout.Flush(); err != nil {
+			return fmt.Errorf("failed to finalize parquet file: %w", err)
+		}
+	}
+	return w.fw.Close()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// csvWriter streams records as CSV rows, after a header row of column names
+// unless it is disabled
+type csvWriter struct {
+	out  io.WriteCloser
+	w    *csv.Writer
+	cols []evrplay.Column
+	row  []string
+}
+
+func newCSVWriter(out io.WriteCloser, cols []evrplay.Column, header bool) (*csvWriter, error) {
+	w := &csvWriter{out: out, w: csv.NewWriter(out), cols: cols, row: make([]string, len(cols))}
+	if !header {
+		return w, nil
+	}
+	for i, c := range cols {
+		w.row[i] = c.Name
+	}
+	if err := w.w.Write(w.row); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvWriter) Write(r *evrplay.JerkRecord) error {
+	for i, c := range w.cols {
+		w.row[i] = formatValue(c.Value(r))
+	}
+	return w.w.Write(w.row)
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		w.out.Close()
+		return err
+	}
+	return w.out.Close()
+}
+
+// contactWriter writes contact events as CSV rows, after a header row
+// unless it is disabled. It is also a RecordSink that ignores records, so it
+// can join a multiSink to be flushed and finalized with the record outputs.
+type contactWriter struct {
+	out io.WriteCloser
+	w   *csv.Writer
+}
+
+func newContactWriter(path string, header bool) (*contactWriter, error) {
+	out, err := createOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &contactWriter{out: out, w: csv.NewWriter(out)}
+	if header {
+		if err := w.w.Write([]string{"sessionid", "time", "user_a", "user_b", "distance"}); err != nil {
+			out.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *contactWriter) WriteContact(e evrplay.ContactEvent) error {
+	return w.w.Write([]string{e.SessionID, formatValue(e.Time), e.UserA, e.UserB, formatValue(e.Distance)})
+}
+
+func (w *contactWriter) Write(*evrplay.JerkRecord) error { return nil }
+
+func (w *contactWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *contactWriter) Close() error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		w.out.Close()
+		return err
+	}
+	return w.out.Close()
+}
+
+// jsonlWriter streams records as one JSON object per line, keyed by column
+// name. NaN and infinite values are written as null since JSON has no
+// representation for them.
+type jsonlWriter struct {
+	out  io.WriteCloser
+	w    *bufio.Writer
+	cols []evrplay.Column
+	buf  []byte
+}
+
+func newJSONLWriter(out io.WriteCloser, cols []evrplay.Column) *jsonlWriter {
+	return &jsonlWriter{out: out, w: bufio.NewWriter(out), cols: cols}
+}
+
+func (w *jsonlWriter) Write(r *evrplay.JerkRecord) error {
+	buf := append(w.buf[:0], '{')
+	for i, c := range w.cols {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendQuote(buf, c.Name)
+		buf = append(buf, ':')
+
+		var err error
+		if buf, err = appendJSONValue(buf, c.Value(r)); err != nil {
+			return err
+		}
+	}
+	buf = append(buf, '}', '\n')
+	w.buf = buf
+
+	_, err := w.w.Write(buf)
+	return err
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *jsonlWriter) Close() error {
+	if err := w.w.Flush(); err != nil {
+		w.out.Close()
+		return err
+	}
+	return w.out.Close()
+}
+
+// appendJSONValue appends the JSON encoding of a column value to buf
+func appendJSONValue(buf []byte, v interface{}) ([]byte, error) {
+	if f, ok := v.(float64); ok {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return append(buf, "null"...), nil
+		}
+		return strconv.AppendFloat(buf, f, 'g', -1, 64), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, b...), nil
+}
+
+// formatValue renders a column value as text without losing precision
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// prepareOutput makes sure the output path can be written as a file,
+// creating its parent directory when needed
+func prepareOutput(path string) error {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return fmt.Errorf("output path %s is a directory", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return nil
+}
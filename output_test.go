@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/thesprockee/evr-playspace/evrplay"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// testWriterOptions returns the options the CLI uses without flags
+func testWriterOptions() writerOptions {
+	return writerOptions{compression: parquet.CompressionCodec_SNAPPY, rowGroupSize: 128 * 1024 * 1024, np: 4}
+}
+
+// writeTestParquet writes n records of one player to a parquet file in a
+// temporary directory and returns its path
+func writeTestParquet(t *testing.T, n int, opts writerOptions) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	w, err := newRecordWriter("parquet", path, evrplay.OutputColumns(evrplay.DefaultConfig()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		tm := float64(i) / 60
+		r := evrplay.JerkRecord{
+			SessionID:  "s",
+			UserID:     "a",
+			Time:       tm,
+			FrameIndex: int64(i),
+			Speed:      1 + math.Sin(tm),
+			Accel:      math.Cos(tm),
+			Jerk:       -math.Sin(tm),
+			JerkValid:  i >= 2,
+		}
+		if err := w.Write(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readFooter returns the footer of the parquet file at path and its row
+// count
+func readFooter(t *testing.T, path string) (*parquet.FileMetaData, int64) {
+	t.Helper()
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetColumnReader(fr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr.Footer, pr.GetNumRows()
+}
+
+func TestParquetRowGroupSize(t *testing.T) {
+	const n = 50000
+	footer, rows := readFooter(t, writeTestParquet(t, n, testWriterOptions()))
+	if rows != n || len(footer.RowGroups) != 1 {
+		t.Fatalf("default options wrote %d rows in %d row groups, want %d in 1", rows, len(footer.RowGroups), n)
+	}
+
+	opts := testWriterOptions()
+	// The writer only checks the row group size after buffering about
+	// np pages per column, so one goroutine makes it check often
+	opts.rowGroupSize, opts.np = 16*1024, 1
+	footer, rows = readFooter(t, writeTestParquet(t, n, opts))
+	if rows != n {
+		t.Errorf("wrote %d rows, want %d", rows, n)
+	}
+	if len(footer.RowGroups) < 4 {
+		t.Errorf("a %d byte row group size wrote %d row groups, want several", opts.rowGroupSize, len(footer.RowGroups))
+	}
+	var total int64
+	for _, rg := range footer.RowGroups {
+		total += rg.NumRows
+	}
+	if total != n {
+		t.Errorf("row groups hold %d rows, want %d", total, n)
+	}
+}
+
+func TestParquetFooterMetadata(t *testing.T) {
+	args := []string{"--wide", "-o", "out.parquet", "capture.jsonl"}
+	opts := testWriterOptions()
+	opts.metadata = fileMetadata(args)
+	footer, _ := readFooter(t, writeTestParquet(t, 10, opts))
+
+	got := make(map[string]string)
+	for _, kv := range footer.KeyValueMetadata {
+		if kv.Value != nil {
+			got[kv.Key] = *kv.Value
+		}
+	}
+	want := map[string]string{
+		"tool_version":   version,
+		"schema_version": strconv.Itoa(evrplay.OutputSchemaVersion),
+		"args":           `["--wide","-o","out.parquet","capture.jsonl"]`,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("footer %s = %q, want %q", k, got[k], v)
+		}
+	}
+}
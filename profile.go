@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// startProfiles starts writing a CPU profile to cpuPath, if set, and returns
+// a function that finishes it and writes a heap profile to memPath, if set.
+// The returned function must run before the process exits, or the CPU
+// profile is left incomplete; calls after the first do nothing.
+func startProfiles(cpuPath, memPath string) (func(), error) {
+	var cpu *os.File
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		cpu = f
+	}
+
+	stop := func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				slog.Error("writing CPU profile", "path", cpuPath, "error", err)
+			}
+		}
+		if memPath != "" {
+			if err := writeHeapProfile(memPath); err != nil {
+				slog.Error("writing memory profile", "path", memPath, "error", err)
+			}
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(stop) }, nil
+}
+
+// writeHeapProfile writes a profile of the live heap to path
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Bring the statistics up to date with every allocation so far
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = "unknown"
+)
+
+// buildCommit returns the commit the binary was built from, falling back to
+// the VCS information the go tool embeds when it was not set explicitly
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// versionString describes the build for --version
+func versionString() string {
+	return fmt.Sprintf("etl %s (commit %s, built %s)", version, buildCommit(), date)
+}